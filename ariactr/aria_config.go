@@ -5,6 +5,9 @@ type Config struct {
 	// Aria2RPCURL is the URL to send RPC calls to.
 	// Defaults to "http://localhost:6800/jsonrpc" when empty.
 	Aria2RPCURL string
+	// Secret is the RPC secret token set with aria2 --rpc-secret option.
+	// Could be set with N2BOT_ARIA2_SECRET environment variable instead.
+	Secret string
 	// PollingInterval is the time in seconds to check active tasks status.
 	// Status determined by batch of aria2.tellStatus calls for every active task.
 	// PollingInterval can't be 0 and defaults to 10 seconds when 0.
@@ -2,59 +2,67 @@ package ariactr
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"n2bot/fatalist"
+	"n2bot/logger"
+	"n2bot/metrics"
+	"n2bot/supervisor"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// PollerComponent is the name of the component started by Run.
+const PollerComponent = "aria2 poller"
+
+// ErrUnreachable is returned when aria2 RPC server couldn't be reached.
+var ErrUnreachable = errors.New("aria2 is unreachable")
+
 // Client is the type to provide communications with aria2.
 type Client struct {
-	httpClient       *http.Client
-	aria2ServerURL   string
-	pollingInterval  uint
-	pollingTaskChan  chan pollingTask
+	httpClient      *http.Client
+	aria2ServerURL  string
+	secret          string
+	pollingInterval uint
+	// gidPerOwner is kept outside of poller so polled tasks survive its restarts.
+	pollingMu        sync.Mutex
+	gidPerOwner      map[string]string
 	taskStatusesChan chan TaskStatus
-	errHandler       *fatalist.Fatalist
+	logger           *logger.Logger
+	supervisor       *supervisor.Supervisor
+	metrics          *metrics.Metrics
+	// wg tracks the poller and the listener.
+	wg sync.WaitGroup
 }
 
-// EnqueueMetadata method consumes ownerID/chatID (it is the same for "private" single user communication)
-// and a magnet link. The GID of created task will be returned on success as the first value.
+// EnqueueMetadata method consumes ownerID/chatID (it is the same for "private" single user communication),
+// a magnet link and optional download options such as proxy settings.
+// The GID of created task will be returned on success as the first value.
 // Error is the second return value.
 // EnqueueMetadata starts the task of collecting torrent metadata (downloading .torrent file) and placing it at
 // the current working dir.
-func (c *Client) EnqueueMetadata(ownerID, magnet string) (string, error) {
-	dir := getWorkdir()
-	req, err := http.NewRequest(
-		http.MethodPost,
-		c.aria2ServerURL,
-		bytes.NewBuffer([]byte(fmt.Sprintf(`{
-			"jsonrpc": "2.0",
-			"id": "%s",
-			"method": "aria2.addUri",
-			"params": [
-			  ["%s"],
-			  {
-				"dir":"%s",
-				"bt-metadata-only": "true",
-				"bt-save-metadata": "true",
-				"bt-stop-timeout":600,
-			  }
-			]
-		  }`, uuid.New(), magnet, dir))),
-	)
+func (c *Client) EnqueueMetadata(ctx context.Context, ownerID, magnet string, opts *DownloadOptions) (string, error) {
+	req, err := c.newRPCRequest(ctx, c.newCall(
+		uuid.New().String(),
+		"aria2.addUri",
+		[]string{magnet},
+		opts.merge(map[string]string{
+			"dir":              getWorkdir(),
+			"bt-metadata-only": "true",
+			"bt-save-metadata": "true",
+			"bt-stop-timeout":  "600",
+		}),
+	))
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
+		c.logger.Error("failed to create metadata request", "owner", ownerID, "error", err)
 		return "", err
 	}
 
@@ -62,121 +70,230 @@ func (c *Client) EnqueueMetadata(ownerID, magnet string) (string, error) {
 }
 
 // EnqueueBT method consumes ownerID/chatID (it is the same for "private" single user communication),
-// a target download dir (where to save downloaded files)
-// and name of the .torrent file in current dir to read.
+// a target download dir (where to save downloaded files),
+// name of the .torrent file in current dir to read and optional download options.
 // The GID of created task will be returned on success as the first value.
 // Error is the second return value.
 // EnqueueBT starts the task of downloading files described in a .torrent file.
-func (c *Client) EnqueueBT(ownerID, dlDir, torrentFile string) (string, error) {
+func (c *Client) EnqueueBT(ctx context.Context, ownerID, dlDir, torrentFile string, opts *DownloadOptions) (string, error) {
 	f, err := ioutil.ReadFile(getWorkdir() + torrentFile)
 	f64str := base64.StdEncoding.EncodeToString(f)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
+		c.logger.Error("failed to read torrent file", "owner", ownerID, "file", torrentFile, "error", err)
 		return "", err
 	}
 	err = mustMkdirAll(dlDir)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
+		c.logger.Error("failed to create download dir", "owner", ownerID, "dir", dlDir, "error", err)
 		return "", err
 	}
-	req, err := http.NewRequest(
-		http.MethodPost,
-		c.aria2ServerURL,
-		bytes.NewBuffer([]byte(fmt.Sprintf(`{
-			"jsonrpc": "2.0",
-			"id": "%s",
-			"method": "aria2.addTorrent",
-			"params": ["%s",
-			  [],
-			  {
-				"dir":"%s",
-				"check-integrity":"true",
-				"continue":"true",
-				"bt-stop-timeout":86400,
-			  }
-			]
-		  }`, uuid.New(), f64str, dlDir))),
-	)
+	req, err := c.newRPCRequest(ctx, c.newCall(
+		uuid.New().String(),
+		"aria2.addTorrent",
+		f64str,
+		[]string{},
+		opts.merge(map[string]string{
+			"dir":             dlDir,
+			"check-integrity": "true",
+			"continue":        "true",
+			"bt-stop-timeout": "86400",
+		}),
+	))
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
+		c.logger.Error("failed to create torrent request", "owner", ownerID, "error", err)
 		return "", err
 	}
 
 	return doDownloadRequest(c, req, ownerID)
 }
 
-// KillTask instructs aria2 to terminate task by provided GID.
-func (c *Client) KillTask(gid string) error {
-	req, err := http.NewRequest(
-		http.MethodPost,
-		c.aria2ServerURL,
-		bytes.NewBuffer(
-			[]byte(
-				fmt.Sprintf(`{
-			"jsonrpc": "2.0",
-			"id": "kill-%s",
-			"method": "aria2.remove",
-			"params": [
-			  %q
-			]
-		  }`, gid, gid),
-			),
-		),
-	)
+// EnqueueURL method consumes ownerID/chatID (it is the same for "private" single user communication),
+// a target download dir, the HTTP(S) or FTP URL to download and optional download options.
+// The GID of created task will be returned on success as the first value.
+// Error is the second return value.
+func (c *Client) EnqueueURL(ctx context.Context, ownerID, dlDir, fileURL string, opts *DownloadOptions) (string, error) {
+	err := mustMkdirAll(dlDir)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.FatalError(err)
-		}
-		return err
+		c.logger.Error("failed to create download dir", "owner", ownerID, "dir", dlDir, "error", err)
+		return "", err
+	}
+	req, err := c.newRPCRequest(ctx, c.newCall(
+		uuid.New().String(),
+		"aria2.addUri",
+		[]string{fileURL},
+		opts.merge(map[string]string{
+			"dir":      dlDir,
+			"continue": "true",
+		}),
+	))
+	if err != nil {
+		c.logger.Error("failed to create URL request", "owner", ownerID, "error", err)
+		return "", err
+	}
+
+	return doDownloadRequest(c, req, ownerID)
+}
+
+// rpcCall is aria2 JSON-RPC method call.
+type rpcCall struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      string        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// rpcReply is aria2 JSON-RPC reply for the calls which result isn't used.
+type rpcReply struct {
+	Error *struct {
+		Message string
+	}
+}
+
+// statusKeys are the keys of TaskStatus requested from aria2.
+var statusKeys = []string{
+	"gid",
+	"infohash",
+	"status",
+	"errorMessage",
+	"completedLength",
+	"totalLength",
+	"uploadLength",
+	"bittorrent",
+}
+
+// newCall creates method call adding RPC secret token if one is set.
+func (c *Client) newCall(id, method string, params ...interface{}) rpcCall {
+	if c.secret != "" {
+		params = append([]interface{}{"token:" + c.secret}, params...)
+	}
+	if params == nil {
+		params = []interface{}{}
+	}
+	return rpcCall{"2.0", id, method, params}
+}
+
+// newRPCRequest creates JSON-RPC request to aria2 with a single call or a batch of calls.
+func (c *Client) newRPCRequest(ctx context.Context, calls interface{}) (*http.Request, error) {
+	body, err := json.Marshal(calls)
+	if err != nil {
+		return nil, err
+	}
+	method := "batch"
+	if call, ok := calls.(rpcCall); ok {
+		method = call.Method
+	}
+	ctx = context.WithValue(ctx, rpcMethodKey{}, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.aria2ServerURL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	_, err = c.httpClient.Do(req)
+	return req, nil
+}
+
+// rpcMethodKey is the context key of the method name the request is made for.
+type rpcMethodKey struct{}
+
+// do sends the request recording its latency and failure.
+// aria2 responds with HTTP error status to the calls it rejects.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	method, _ := req.Context().Value(rpcMethodKey{}).(string)
+	start := time.Now()
+	res, err := c.httpClient.Do(req)
+	c.metrics.ObserveRPC(method, time.Since(start), err != nil || res.StatusCode >= http.StatusBadRequest)
+	return res, err
+}
+
+// KillTask instructs aria2 to terminate task by provided GID.
+func (c *Client) KillTask(ctx context.Context, gid string) error {
+	err := c.callWithGID(ctx, "aria2.remove", gid)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.FatalError(err)
-		}
+		c.logger.Error("failed to kill task", "gid", gid, "error", err)
+	}
+	return err
+}
+
+// PauseTask instructs aria2 to pause task by provided GID.
+// Paused task keeps its progress and is polled as usual.
+func (c *Client) PauseTask(ctx context.Context, gid string) error {
+	err := c.callWithGID(ctx, "aria2.pause", gid)
+	if err != nil {
+		c.logger.Error("failed to pause task", "gid", gid, "error", err)
+	}
+	return err
+}
+
+// UnpauseTask instructs aria2 to resume paused task by provided GID.
+func (c *Client) UnpauseTask(ctx context.Context, gid string) error {
+	err := c.callWithGID(ctx, "aria2.unpause", gid)
+	if err != nil {
+		c.logger.Error("failed to unpause task", "gid", gid, "error", err)
 	}
 	return err
 }
 
+// TellStatus reports the status of aria2 task by provided GID.
+func (c *Client) TellStatus(ctx context.Context, gid string) (TaskStatus, error) {
+	statuses, err := c.TellStatuses(ctx, []string{gid})
+	if err != nil {
+		return TaskStatus{}, err
+	}
+	if len(statuses) == 0 {
+		return TaskStatus{}, fmt.Errorf("no status of task %s", gid)
+	}
+	return statuses[0], nil
+}
+
+// TellStatuses reports the statuses of aria2 tasks by provided GIDs with a single batch request.
+// Statuses of the tasks aria2 doesn't know have "error" status and the GID set.
+func (c *Client) TellStatuses(ctx context.Context, gids []string) ([]TaskStatus, error) {
+	if len(gids) == 0 {
+		return []TaskStatus{}, nil
+	}
+	calls := []rpcCall{}
+	for _, gid := range gids {
+		calls = append(calls, c.newCall(gid, "aria2.tellStatus", gid, statusKeys))
+	}
+	req, err := c.newRPCRequest(ctx, calls)
+	if err != nil {
+		return nil, err
+	}
+	statuses, _, err := c.doStatusRequest(req)
+	return statuses, err
+}
+
+// callWithGID calls the method taking GID as the only parameter
+// and returns the error aria2 replies with, e.g. when there is no such task.
+func (c *Client) callWithGID(ctx context.Context, method, gid string) error {
+	req, err := c.newRPCRequest(ctx, c.newCall(method+"-"+gid, method, gid))
+	if err != nil {
+		return err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer res.Body.Close()
+	var reply rpcReply
+	if err = json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		return err
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("%s: %s", method, reply.Error.Message)
+	}
+	return nil
+}
+
 // TellActive reports about aria2 tasks in the current session.
 // Returns an array of TaskStatus objects and a error.
-func (c *Client) TellActive() ([]TaskStatus, error) {
+func (c *Client) TellActive(ctx context.Context) ([]TaskStatus, error) {
 	var statuses []TaskStatus
 	var err error
-	req, err := http.NewRequest(
-		http.MethodPost,
-		c.aria2ServerURL,
-		bytes.NewBuffer([]byte(`{
-			"jsonrpc": "2.0",
-			"id": "tellActive",
-			"method": "aria2.tellActive",
-			"params": [
-						[
-						"gid", 
-						"infohash", 
-						"status", 
-						"errorMessage",
-						"completedLength", 
-						"totalLength", 
-						"bittorrent"
-						]
-					]
-				}`)),
-	)
+	req, err := c.newRPCRequest(ctx, c.newCall("tellActive", "aria2.tellActive", statusKeys))
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.FatalError(err)
-		}
+		c.logger.Error("failed to create tellActive request", "error", err)
 		return statuses, err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	statuses, _, err = c.doStatusRequest(req)
 	return statuses, err
@@ -184,102 +301,151 @@ func (c *Client) TellActive() ([]TaskStatus, error) {
 
 // AddPollingTask starts polling for stored in database GIDs per owner.
 func (c *Client) AddPollingTask(ownerID, gid string) {
-	c.pollingTaskChan <- pollingTask{
-		ownerID,
-		gid,
-	}
+	c.pollingMu.Lock()
+	c.gidPerOwner[gid] = ownerID
+	c.pollingMu.Unlock()
+}
+
+// HTTPClient returns the client used for RPC calls, e.g. to set up a proxy.
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
+}
+
+// SetLogger sets the logger to Client.
+func (c *Client) SetLogger(l *logger.Logger) {
+	c.logger = l.For("aria2")
+}
+
+// SetMetrics sets the metrics to record RPC calls latency and failures to.
+func (c *Client) SetMetrics(m *metrics.Metrics) {
+	c.metrics = m
 }
 
-// SetErrorHandler sets a error handler function to Client.
-func (c *Client) SetErrorHandler(h *fatalist.Fatalist) {
-	c.errHandler = h
+// SetSupervisor sets the supervisor restarting failed poller.
+// Without supervisor failed poller is logged and isn't restarted.
+func (c *Client) SetSupervisor(s *supervisor.Supervisor) {
+	c.supervisor = s
 }
 
 // Run polling and set a listener/handler if any.
 // If no listener is provided results would be printed to stdout.
-func (c *Client) Run(onStatus ...func(ts TaskStatus)) {
+// Polling and listening stop when ctx is done, call Wait to wait for them.
+func (c *Client) Run(ctx context.Context, onStatus ...func(ts TaskStatus)) {
 	if onStatus == nil {
 		c.runWithListener(
+			ctx,
 			func(ts TaskStatus) { fmt.Println("TaskStatus", ts) },
 		)
 		return
 	}
-	c.runWithListener(onStatus[0])
+	c.runWithListener(ctx, onStatus[0])
 	return
 }
 
-func (c *Client) runWithListener(l func(ts TaskStatus)) {
+// Wait waits for the poller and the listener to stop after Run's ctx is done.
+// Wait gives up when ctx is done.
+func (c *Client) Wait(ctx context.Context) error {
+	stopped := make(chan struct{})
 	go func() {
-		for {
-			ts := <-c.taskStatusesChan
-			l(ts)
-		}
+		c.wg.Wait()
+		close(stopped)
 	}()
-	go c.startPolling()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (c *Client) startPolling() {
-	gidPerOwner := map[string]string{}
-	timeoutChan := make(chan byte)
+func (c *Client) runWithListener(ctx context.Context, l func(ts TaskStatus)) {
+	c.wg.Add(2)
 	go func() {
+		defer c.wg.Done()
 		for {
-			time.Sleep(time.Duration(c.pollingInterval) * time.Second)
 			select {
-			case timeoutChan <- '1':
-			default:
+			case ts := <-c.taskStatusesChan:
+				l(ts)
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
+	if c.supervisor != nil {
+		done := c.supervisor.Go(ctx, PollerComponent, c.startPolling)
+		go func() {
+			<-done
+			c.wg.Done()
+		}()
+		return
+	}
+	go func() {
+		defer c.wg.Done()
+		if err := c.startPolling(ctx); err != nil {
+			c.logger.Error("poller stopped", "error", err)
+		}
+	}()
+}
+
+// startPolling returns when aria2 becomes unreachable or ctx is done.
+// aria2 availability is checked with aria2.getVersion while there is nothing to poll.
+func (c *Client) startPolling(ctx context.Context) error {
+	ticker := time.NewTicker(time.Duration(c.pollingInterval) * time.Second)
+	defer ticker.Stop()
 	for {
 		select {
-		case t := <-c.pollingTaskChan:
-			gidPerOwner[t.gid] = t.ownerID
-		case <-timeoutChan:
-			if len(gidPerOwner) == 0 {
-				break
-			}
-			calls := []string{}
-			for k := range gidPerOwner {
-				statusCall := fmt.Sprintf(`{
-					"jsonrpc": "2.0",
-					"id": "%s",
-					"method": "aria2.tellStatus",
-					"params": ["%s", 
-								[
-								"gid", 
-								"infohash", 
-								"status", 
-								"errorMessage",
-								"completedLength", 
-								"totalLength", 
-								"bittorrent"
-								]
-							]
-						}`, k, k)
-				calls = append(calls, statusCall)
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		c.pollingMu.Lock()
+		calls := []rpcCall{}
+		for k := range c.gidPerOwner {
+			calls = append(calls, c.newCall(k, "aria2.tellStatus", k, statusKeys))
+		}
+		c.pollingMu.Unlock()
+		if len(calls) == 0 {
+			err := c.checkConnectivity(ctx)
+			if ctx.Err() != nil {
+				return nil
 			}
-			req, err := http.NewRequest(
-				http.MethodPost,
-				c.aria2ServerURL,
-				bytes.NewBuffer([]byte("["+strings.Join(calls, ",")+"]")),
-			)
 			if err != nil {
-				if c.errHandler != nil {
-					c.errHandler.FatalError(err)
-				}
-				return
+				return fmt.Errorf("%w: %v", ErrUnreachable, err)
 			}
-			req.Header.Set("Content-Type", "application/json")
+			c.supervisor.MarkUp(PollerComponent)
+			continue
+		}
+		req, err := c.newRPCRequest(ctx, calls)
+		if err != nil {
+			return err
+		}
 
-			statuses, deleteGid, _ := c.doStatusRequest(req)
-			for _, s := range statuses {
-				s.OwnerID = gidPerOwner[s.GID]
-				c.taskStatusesChan <- s
-			}
-			for _, g := range deleteGid {
-				delete(gidPerOwner, g)
+		statuses, deleteGid, err := c.doStatusRequest(req)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, ErrUnreachable) {
+			return err
+		}
+		c.supervisor.MarkUp(PollerComponent)
+		c.pollingMu.Lock()
+		for i := range statuses {
+			statuses[i].OwnerID = c.gidPerOwner[statuses[i].GID]
+		}
+		c.pollingMu.Unlock()
+		for _, s := range statuses {
+			select {
+			case c.taskStatusesChan <- s:
+			case <-ctx.Done():
+				// Undelivered final statuses are polled again on the next start.
+				return nil
 			}
 		}
+		c.pollingMu.Lock()
+		for _, g := range deleteGid {
+			delete(c.gidPerOwner, g)
+		}
+		c.pollingMu.Unlock()
 	}
 }
 
@@ -288,12 +454,9 @@ func (c *Client) doStatusRequest(req *http.Request) ([]TaskStatus, []string, err
 	deleteGids := []string{}
 	var err error
 
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(req)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.FatalError(err)
-		}
-		return statuses, deleteGids, err
+		return statuses, deleteGids, fmt.Errorf("%w: %v", ErrUnreachable, err)
 	}
 	defer res.Body.Close()
 
@@ -304,9 +467,7 @@ func (c *Client) doStatusRequest(req *http.Request) ([]TaskStatus, []string, err
 		var oneElRaw map[string]json.RawMessage
 		err = json.Unmarshal(bodyByt, &oneElRaw)
 		if err != nil {
-			if c.errHandler != nil {
-				c.errHandler.LogError(err)
-			}
+			c.logger.Error("failed to decode statuses", "error", err)
 			return statuses, deleteGids, err
 		}
 		statusesRaw = []map[string]json.RawMessage{}
@@ -337,13 +498,10 @@ func (c *Client) doStatusRequest(req *http.Request) ([]TaskStatus, []string, err
 			var ts TaskStatus
 			json.Unmarshal(s["result"], &ts)
 			statuses = append(statuses, ts)
-			compLen, _ := ts.CompletedLength.Int64()
-			totlLen, _ := ts.TotalLength.Int64()
+			// Seeding tasks are still polled to report when seeding is over.
 			if ts.Status == "error" ||
 				ts.Status == "complete" ||
-				ts.Status == "removed" ||
-				(compLen != 0 &&
-					compLen == totlLen) {
+				ts.Status == "removed" {
 				deleteGids = append(deleteGids, ts.GID)
 			}
 		}
@@ -351,11 +509,6 @@ func (c *Client) doStatusRequest(req *http.Request) ([]TaskStatus, []string, err
 	return statuses, deleteGids, err
 }
 
-type pollingTask struct {
-	ownerID string
-	gid     string
-}
-
 // TaskStatus is an object for reporting the status and some additional metadata
 // of a runing aria2 task.
 type TaskStatus struct {
@@ -366,6 +519,7 @@ type TaskStatus struct {
 	ErrorMessage    string
 	CompletedLength json.Number
 	TotalLength     json.Number
+	UploadLength    json.Number
 	Bittorrent      bittorrentInfo
 }
 
@@ -386,31 +540,45 @@ func NewClient(cfg *Config) (*Client, error) {
 	if cfg.PollingInterval == 0 {
 		cfg.PollingInterval = 10
 	}
-	httpClient := &http.Client{}
-	return &Client{
-		httpClient,
+	c := &Client{
+		&http.Client{},
 		cfg.Aria2RPCURL,
+		cfg.Secret,
 		cfg.PollingInterval,
-		make(chan pollingTask),
+		sync.Mutex{},
+		map[string]string{},
 		make(chan TaskStatus),
 		nil,
-	}, checkConnectivity(httpClient, cfg.Aria2RPCURL)
+		nil,
+		nil,
+		sync.WaitGroup{},
+	}
+	return c, c.checkConnectivity(context.Background())
 }
 
-func checkConnectivity(client *http.Client, rpcURL string) error {
-	req, err := http.NewRequest(http.MethodPost, rpcURL, bytes.NewBuffer([]byte(`{
-		"jsonrpc": "2.0",
-		"id": "%s",
-		"method": "aria2.getVersion"}`)))
+// Ping checks that aria2 is reachable and accepts the RPC secret.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.checkConnectivity(ctx)
+}
+
+func (c *Client) checkConnectivity(ctx context.Context) error {
+	req, err := c.newRPCRequest(ctx, c.newCall("getVersion", "aria2.getVersion"))
 	if err != nil {
 		return err
 	}
-	res, err := client.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
-	ioutil.ReadAll(res.Body)
+	// aria2 replies with "Unauthorized" error when the secret is wrong.
+	var reply rpcReply
+	if err = json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		return err
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("aria2 getVersion: %s", reply.Error.Message)
+	}
 	return nil
 }
 
@@ -431,20 +599,16 @@ func getWorkdir() (dir string) {
 func doDownloadRequest(c *Client, req *http.Request, ownerID string) (string, error) {
 	req.Header.Set("Content-Type", "application/json")
 
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(req)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
+		c.logger.Error("download request failed", "owner", ownerID, "error", err)
 		return "", err
 	}
 	defer res.Body.Close()
 	var resultBody map[string]json.RawMessage
 	err = json.NewDecoder(res.Body).Decode(&resultBody)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
+		c.logger.Error("failed to decode download response", "owner", ownerID, "error", err)
 		return "", err
 	}
 
@@ -0,0 +1,38 @@
+package ariactr
+
+// DownloadOptions are the per download aria2 options.
+// Empty values aren't passed to aria2 so its global options apply.
+type DownloadOptions struct {
+	// AllProxy is the proxy for all protocols, "[http://][USER:PASSWORD@]HOST[:PORT]".
+	// aria2 supports HTTP proxies only.
+	AllProxy string
+	// AllProxyUser and AllProxyPasswd are the AllProxy credentials.
+	AllProxyUser   string
+	AllProxyPasswd string
+	// HTTPProxy is the proxy for HTTP downloads, overrides AllProxy for them.
+	HTTPProxy string
+	// HTTPProxyUser and HTTPProxyPasswd are the HTTPProxy credentials.
+	HTTPProxyUser   string
+	HTTPProxyPasswd string
+}
+
+// merge adds non-empty options to aria2 options map.
+// It is safe to call on nil DownloadOptions.
+func (o *DownloadOptions) merge(options map[string]string) map[string]string {
+	if o == nil {
+		return options
+	}
+	for k, v := range map[string]string{
+		"all-proxy":         o.AllProxy,
+		"all-proxy-user":    o.AllProxyUser,
+		"all-proxy-passwd":  o.AllProxyPasswd,
+		"http-proxy":        o.HTTPProxy,
+		"http-proxy-user":   o.HTTPProxyUser,
+		"http-proxy-passwd": o.HTTPProxyPasswd,
+	} {
+		if v != "" {
+			options[k] = v
+		}
+	}
+	return options
+}
@@ -0,0 +1,50 @@
+package classr
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by PredictClass while classificator is skipped
+// after too many consecutive failures.
+var ErrCircuitOpen = errors.New("classificator is temporarily unavailable")
+
+// breaker counts consecutive failures and opens for cooldown once threshold is reached.
+type breaker struct {
+	mu        sync.Mutex
+	threshold uint
+	cooldown  time.Duration
+	failures  uint
+	openUntil time.Time
+}
+
+// allow reports whether a call could be made right now.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().After(b.openUntil)
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.threshold {
+		b.failures = 0
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+func newBreaker(threshold uint, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
@@ -0,0 +1,46 @@
+package classr
+
+import (
+	"strings"
+	"sync"
+)
+
+// predictionCache keeps predictions keyed by lowercased infohash.
+// When full the oldest entry is evicted.
+type predictionCache struct {
+	mu    sync.Mutex
+	size  int
+	order []string
+	items map[string]TypePrediction
+}
+
+func (pc *predictionCache) get(infohash string) (TypePrediction, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	p, ok := pc.items[strings.ToLower(infohash)]
+	return p, ok
+}
+
+func (pc *predictionCache) put(infohash string, p TypePrediction) {
+	if infohash == "" {
+		return
+	}
+	key := strings.ToLower(infohash)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if _, ok := pc.items[key]; !ok {
+		pc.order = append(pc.order, key)
+	}
+	pc.items[key] = p
+	for len(pc.order) > pc.size {
+		delete(pc.items, pc.order[0])
+		pc.order = pc.order[1:]
+	}
+}
+
+func newPredictionCache(size int) *predictionCache {
+	return &predictionCache{
+		size:  size,
+		items: map[string]TypePrediction{},
+	}
+}
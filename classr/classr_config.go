@@ -4,4 +4,23 @@ package classr
 type Config struct {
 	// URL to send torrent file to for classification.
 	URL string
+	// TopK is the number of most probable labels to ask classificator for.
+	// It is passed as "k" query parameter, older classificator versions ignore it.
+	// TopK defaults to 3 when 0.
+	TopK uint
+	// Timeout is the time in seconds to wait for classificator to respond.
+	// Timeout defaults to 30 seconds when 0.
+	Timeout uint
+	// FailureThreshold is the number of consecutive failed calls
+	// after which classificator is skipped for Cooldown seconds.
+	// FailureThreshold defaults to 3 when 0.
+	FailureThreshold uint
+	// Cooldown is the time in seconds to skip classificator calls
+	// once FailureThreshold is reached.
+	// Cooldown defaults to 300 seconds when 0.
+	Cooldown uint
+	// CacheSize is the number of predictions kept in memory keyed by infohash.
+	// The oldest predictions are evicted first.
+	// CacheSize defaults to 1000 when 0.
+	CacheSize uint
 }
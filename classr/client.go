@@ -1,79 +1,185 @@
 package classr
 
 import (
+	"context"
 	"encoding/json"
-	"n2bot/fatalist"
+	"fmt"
+	"n2bot/logger"
+	"n2bot/metrics"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"time"
 )
 
 // Client is the type to provide communications with classificator.
 type Client struct {
 	httpClient *http.Client
 	url        string
-	errHandler *fatalist.Fatalist
+	topK       uint
+	breaker    *breaker
+	cache      *predictionCache
+	logger     *logger.Logger
+	metrics    *metrics.Metrics
 }
 
-// PredictClass takes .torrent file path and calls 'classificator' service.
+// PredictClass takes torrent infohash and .torrent file path and calls 'classificator' service.
+// Predictions are cached by infohash so the same torrent is classified only once.
+// While the service keeps failing calls are skipped and ErrCircuitOpen is returned.
 // Returns TypePrediction and error.
-func (c *Client) PredictClass(fp string) (TypePrediction, error) {
+func (c *Client) PredictClass(ctx context.Context, infohash, fp string) (TypePrediction, error) {
+	if p, ok := c.cache.get(infohash); ok {
+		c.metrics.ClassifierCall("cached")
+		return p, nil
+	}
+	if c.breaker.allow() == false {
+		c.metrics.ClassifierCall("skipped")
+		return TypePrediction{}, ErrCircuitOpen
+	}
+	prediction, err := c.requestPrediction(ctx, fp)
+	if err != nil {
+		c.metrics.ClassifierCall("error")
+		c.breaker.failure()
+		c.logger.Error("prediction failed", "infohash", infohash, "error", err)
+		return prediction, err
+	}
+	c.metrics.ClassifierCall("ok")
+	c.metrics.ObserveConfidence(prediction.Confidence)
+	c.breaker.success()
+	c.cache.put(infohash, prediction)
+
+	return prediction, nil
+}
+
+func (c *Client) requestPrediction(ctx context.Context, fp string) (TypePrediction, error) {
 	var prediction TypePrediction
-	var err error
 
 	f, err := os.Open(fp)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
 		return prediction, err
 	}
 	defer f.Close()
-	req, err := http.NewRequest("POST", c.url, f)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, f)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
 		return prediction, err
 	}
 	req.Header.Add("Content-Type", "application/octet-stream")
+	q := req.URL.Query()
+	q.Set("k", strconv.Itoa(int(c.topK)))
+	req.URL.RawQuery = q.Encode()
 	res, err := c.httpClient.Do(req)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
 		return prediction, err
 	}
 	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return prediction, fmt.Errorf("classificator responded with %s", res.Status)
+	}
 
 	err = json.NewDecoder(res.Body).Decode(&prediction)
 	if err != nil {
-		if c.errHandler != nil {
-			c.errHandler.LogError(err)
-		}
 		return prediction, err
 	}
+	prediction.normalize()
+	if len(prediction.TopK) > int(c.topK) {
+		prediction.TopK = prediction.TopK[:c.topK]
+	}
+	return prediction, nil
+}
 
-	return prediction, err
+// Ping checks that classificator is reachable, any HTTP response means it is.
+// ErrCircuitOpen is returned while calls are skipped after failures.
+func (c *Client) Ping(ctx context.Context) error {
+	if c.breaker.allow() == false {
+		return ErrCircuitOpen
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
 }
 
-// SetErrorHandler sets a error handler function to Client.
-func (c *Client) SetErrorHandler(h *fatalist.Fatalist) {
-	c.errHandler = h
+// HTTPClient returns the client used to call classificator, e.g. to set up a proxy.
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
+}
+
+// SetLogger sets the logger to Client.
+func (c *Client) SetLogger(l *logger.Logger) {
+	c.logger = l.For("classificator")
+}
+
+// SetMetrics sets the metrics to count predictions to.
+func (c *Client) SetMetrics(m *metrics.Metrics) {
+	c.metrics = m
 }
 
 // TypePrediction is the handful representation of 'classificator' results.
 // Contains a Type prediction for provided .torrent and a Confidence as a float32.
 // However Confidence is over .5 and below 1.0 whenever everything's went smooth.
+// TopK holds all the labels returned by classificator ordered by probability,
+// the first one is always the same as Type. Older classificator versions respond
+// with just {prediction, confidence} and TopK then has the single element.
+// Explanation is an optional human readable reason of the prediction.
 type TypePrediction struct {
-	Type       string  `json:"prediction"`
-	Confidence float32 `json:"confidence"`
+	Type        string             `json:"prediction"`
+	Confidence  float32            `json:"confidence"`
+	TopK        []LabelProbability `json:"predictions,omitempty"`
+	Explanation string             `json:"explanation,omitempty"`
+}
+
+// LabelProbability is a single label of top-k prediction with its probability.
+type LabelProbability struct {
+	Type        string  `json:"prediction"`
+	Probability float32 `json:"confidence"`
+}
+
+// normalize makes Type, Confidence and TopK consistent whatever shape the response had.
+func (p *TypePrediction) normalize() {
+	sort.SliceStable(p.TopK, func(i, j int) bool {
+		return p.TopK[i].Probability > p.TopK[j].Probability
+	})
+	if len(p.TopK) == 0 {
+		if p.Type != "" {
+			p.TopK = []LabelProbability{{p.Type, p.Confidence}}
+		}
+		return
+	}
+	p.Type = p.TopK[0].Type
+	p.Confidence = p.TopK[0].Probability
 }
 
 // NewClient creates new Client from config.
 func NewClient(cfg *Config) *Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30
+	}
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = 300
+	}
+	if cfg.CacheSize == 0 {
+		cfg.CacheSize = 1000
+	}
+	if cfg.TopK == 0 {
+		cfg.TopK = 3
+	}
 	return &Client{
-		&http.Client{},
+		&http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
 		cfg.URL,
+		cfg.TopK,
+		newBreaker(cfg.FailureThreshold, time.Duration(cfg.Cooldown)*time.Second),
+		newPredictionCache(int(cfg.CacheSize)),
+		nil,
 		nil,
 	}
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"n2bot/ariactr"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apiConfig configures local HTTP JSON API for scripts and dashboards.
+type apiConfig struct {
+	// Listen is the address to serve API, health checks, metrics and web dashboard on, e.g. "127.0.0.1:8090".
+	// Nothing is served when empty.
+	Listen string
+	// Token is the secret every request carries in "Authorization: Bearer <token>" header.
+	// /api/ isn't served when empty, so health checks could be served without exposing the API.
+	// N2BOT_API_TOKEN environment variable overrides it.
+	Token string
+	// Metrics serves Prometheus metrics at /metrics on the same address, no token is needed to scrape them.
+	Metrics bool
+}
+
+// apiTask is the task as API reports it.
+type apiTask struct {
+	Owner      string     `json:"owner"`
+	GID        string     `json:"gid"`
+	Stage      string     `json:"stage"`
+	Category   string     `json:"category"`
+	Name       string     `json:"name"`
+	Infohash   string     `json:"infohash,omitempty"`
+	Dir        string     `json:"dir,omitempty"`
+	Proxy      string     `json:"proxy,omitempty"`
+	Started    *time.Time `json:"started,omitempty"`
+	Downloaded *time.Time `json:"downloaded,omitempty"`
+	// Status is aria2 status of the task, it is only reported for a single task.
+	Status *apiTaskStatus `json:"status,omitempty"`
+}
+
+type apiTaskStatus struct {
+	Status          string `json:"status"`
+	ErrorMessage    string `json:"errorMessage,omitempty"`
+	CompletedLength int64  `json:"completedLength"`
+	TotalLength     int64  `json:"totalLength"`
+	UploadLength    int64  `json:"uploadLength"`
+}
+
+// apiNewTask is the body of the request adding a download.
+type apiNewTask struct {
+	// User is the chat ID the task is added for, notifications are sent to this chat.
+	User   string `json:"user"`
+	Magnet string `json:"magnet"`
+	URL    string `json:"url"`
+	// Category, Dir and Proxy are the same as -t, -d and -p chat flags.
+	Category string `json:"category"`
+	Dir      string `json:"dir"`
+	Proxy    string `json:"proxy"`
+}
+
+// serveAPI starts serving API in background and returns the server to shut down on exit.
+// Listening errors are returned right away, so misconfigured address stops the bot on start.
+func serveAPI(cfg *apiConfig, health *healthConfig, app *application) (*http.Server, error) {
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		Handler:           httpRoutes(cfg, health, app),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && errors.Is(err, http.ErrServerClosed) == false {
+			app.log.Error("API server stopped", "error", err)
+		}
+	}()
+	app.log.Info("HTTP server started", "address", ln.Addr().String(), "api", cfg.Token != "")
+	return srv, nil
+}
+
+// httpRoutes serves API with token if it is set, metrics and health checks are served without it.
+// Web dashboard has its own session auth.
+func httpRoutes(cfg *apiConfig, health *healthConfig, app *application) *http.ServeMux {
+	mux := http.NewServeMux()
+	if cfg.Token != "" {
+		mux.Handle("/api/", withToken(cfg.Token, apiRoutes(app)))
+	}
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, checkLiveness(health, app))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, checkReadiness(r.Context(), health, app))
+	})
+	if cfg.Metrics {
+		mux.Handle("GET /metrics", app.metrics.Handler())
+	}
+	if app.web != nil {
+		webRoutes(mux, app)
+	}
+	return mux
+}
+
+func apiRoutes(app *application) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/tasks", func(w http.ResponseWriter, r *http.Request) {
+		apiListTasks(w, r, app)
+	})
+	mux.HandleFunc("POST /api/v1/tasks", func(w http.ResponseWriter, r *http.Request) {
+		apiAddTask(w, r, app)
+	})
+	mux.HandleFunc("GET /api/v1/tasks/{gid}", func(w http.ResponseWriter, r *http.Request) {
+		apiGetTask(w, r, app)
+	})
+	mux.HandleFunc("DELETE /api/v1/tasks/{gid}", func(w http.ResponseWriter, r *http.Request) {
+		apiChangeTask(w, r, app, killTask)
+	})
+	mux.HandleFunc("POST /api/v1/tasks/{gid}/pause", func(w http.ResponseWriter, r *http.Request) {
+		apiChangeTask(w, r, app, func(ctx context.Context, owner, gid string, app *application) error {
+			return pauseTask(ctx, owner, gid, true, app)
+		})
+	})
+	mux.HandleFunc("POST /api/v1/tasks/{gid}/resume", func(w http.ResponseWriter, r *http.Request) {
+		apiChangeTask(w, r, app, func(ctx context.Context, owner, gid string, app *application) error {
+			return pauseTask(ctx, owner, gid, false, app)
+		})
+	})
+	return mux
+}
+
+// withToken rejects the requests without valid bearer token.
+func withToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok == false || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, errors.New("invalid API token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiListTasks lists the tasks of the user set with "user" query parameter or of all users.
+func apiListTasks(w http.ResponseWriter, r *http.Request, app *application) {
+	all, err := app.tasks.all()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	user := r.URL.Query().Get("user")
+	tasks := []apiTask{}
+	for owner, byGID := range all {
+		if user != "" && owner != user {
+			continue
+		}
+		for gid, t := range byGID {
+			tasks = append(tasks, newAPITask(owner, gid, &t))
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Owner != tasks[j].Owner {
+			return tasks[i].Owner < tasks[j].Owner
+		}
+		return tasks[i].GID < tasks[j].GID
+	})
+	writeAPIResult(w, http.StatusOK, tasks)
+}
+
+// apiAddTask adds magnet or URL download the same way chat message does.
+func apiAddTask(w http.ResponseWriter, r *http.Request, app *application) {
+	var req apiNewTask
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if isUser(req.User, app) == false {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("%q isn't a bot user", req.User))
+		return
+	}
+	if (req.Magnet == "") == (req.URL == "") {
+		writeAPIError(w, http.StatusBadRequest, errors.New("either magnet or url must be set"))
+		return
+	}
+	if req.Category != "" && stringToDlType(req.Category) == unknown {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("unknown category %q", req.Category))
+		return
+	}
+	task := &botTask{
+		DlType:   req.Category,
+		DlSubdir: req.Dir,
+		Magnet:   req.Magnet,
+		Proxy:    req.Proxy,
+	}
+	var gid string
+	var err error
+	if req.Magnet != "" {
+		gid, err = enqueueUniqueMagnet(r.Context(), req.User, task, app)
+	} else {
+		gid, err = enqueueURL(r.Context(), req.User, req.URL, task, app)
+	}
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	dInfo, _, err := app.tasks.get(req.User, gid)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAPIResult(w, http.StatusCreated, newAPITask(req.User, gid, &dInfo))
+}
+
+// apiGetTask reports the stored task along with its aria2 status.
+func apiGetTask(w http.ResponseWriter, r *http.Request, app *application) {
+	gid := r.PathValue("gid")
+	owner, err := app.tasks.ownerOf(gid)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	dInfo, ok, err := app.tasks.get(owner, gid)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if ok == false {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("%w with ID %s", errNoTask, gid))
+		return
+	}
+	task := newAPITask(owner, gid, &dInfo)
+	ts, err := app.ariaClient.TellStatus(r.Context(), gid)
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	task.Status = newAPITaskStatus(&ts)
+	writeAPIResult(w, http.StatusOK, task)
+}
+
+// apiChangeTask applies the change to the task on behalf of its owner.
+func apiChangeTask(
+	w http.ResponseWriter,
+	r *http.Request,
+	app *application,
+	change func(ctx context.Context, owner, gid string, app *application) error,
+) {
+	gid := r.PathValue("gid")
+	owner, err := app.tasks.ownerOf(gid)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err = change(r.Context(), owner, gid, app); err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newAPITask reports the task, proxy credentials of the tasks stored before they were dropped are redacted.
+func newAPITask(owner, gid string, t *downloadTaskInfo) apiTask {
+	task := apiTask{
+		Owner:    owner,
+		GID:      gid,
+		Stage:    t.TaskStage.String(),
+		Category: t.DLType.String(),
+		Name:     t.BTName,
+		Infohash: t.MagnetHash,
+		Dir:      t.DLDir,
+		Proxy:    redactProxy(t.Proxy),
+	}
+	if t.Started.IsZero() == false {
+		task.Started = &t.Started
+	}
+	if t.Downloaded.IsZero() == false {
+		task.Downloaded = &t.Downloaded
+	}
+	return task
+}
+
+func newAPITaskStatus(ts *ariactr.TaskStatus) *apiTaskStatus {
+	compLen, _ := ts.CompletedLength.Int64()
+	totlLen, _ := ts.TotalLength.Int64()
+	upLen, _ := ts.UploadLength.Int64()
+	return &apiTaskStatus{ts.Status, ts.ErrorMessage, compLen, totlLen, upLen}
+}
+
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNoTask):
+		return http.StatusNotFound
+	case errors.Is(err, errDuplicate):
+		return http.StatusConflict
+	case errors.Is(err, ariactr.ErrUnreachable):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeAPIResult(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, err error) {
+	writeAPIResult(w, code, map[string]string{"error": err.Error()})
+}
+
+func isUser(chatID string, app *application) bool {
+	for _, u := range app.settings().users {
+		if u == chatID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"n2bot/storage"
+	"n2bot/tg"
+	"time"
+)
+
+// runScheduledBackups writes online backups to configured directory every IntervalHours.
+// Nothing is scheduled if backup directory isn't set. Backups stop when ctx is done.
+func runScheduledBackups(ctx context.Context, app *application) {
+	cfg := app.backupCfg
+	if cfg.Dir == "" {
+		return
+	}
+	if cfg.IntervalHours == 0 {
+		cfg.IntervalHours = 24
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.IntervalHours) * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			path, err := storage.Backup(app.db, cfg.Dir, int(cfg.Keep))
+			if err != nil {
+				app.log.Error("scheduled backup failed", "error", err)
+				continue
+			}
+			app.log.Info("scheduled backup written", "path", path)
+		}
+	}()
+}
+
+// handleBackup makes online backup on admin request.
+func handleBackup(chatID string, app *application) {
+	if isAdmin(chatID, app) == false {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			"Only admins could make backups.",
+		)
+		return
+	}
+	if app.backupCfg.Dir == "" {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			"Backup directory isn't configured.",
+		)
+		return
+	}
+	path, err := storage.Backup(app.db, app.backupCfg.Dir, int(app.backupCfg.Keep))
+	if err != nil {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			err.Error(),
+		)
+		return
+	}
+	app.tgClient.GetOutChan() <- tg.NewTextMessage(
+		chatID,
+		fmt.Sprintf("Backup written to %s", path),
+	)
+}
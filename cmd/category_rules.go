@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"n2bot/classr"
+	"strings"
+)
+
+// acceptRule overrides confidence thresholds for the torrents it matches.
+// Rules are checked in the order of config, the first matching rule decides.
+type acceptRule struct {
+	// Name is used to log which rule triggered the decision.
+	Name string
+	// Category is the predicted category the rule applies to.
+	// Empty Category matches any prediction.
+	Category string
+	// MinSizeGB and MaxSizeGB limit the total size of torrent content the rule applies to.
+	// Zero means no limit.
+	MinSizeGB float64 `toml:"minSizeGB"`
+	MaxSizeGB float64 `toml:"maxSizeGB"`
+	// Action is either "ask" to always ask user to confirm the category
+	// or "accept" to accept the prediction if its confidence is at least MinConfidence.
+	Action string
+	// MinConfidence is the value of 0 to 1 used with "accept" action.
+	MinConfidence float32
+}
+
+// categoryDecision is the outcome of checking prediction against thresholds and rules.
+type categoryDecision struct {
+	accept bool
+	// reason names the rule or threshold that triggered the decision.
+	reason string
+}
+
+const bytesInGB = 1 << 30
+
+// matches reports whether the rule applies to the prediction of torrent of size bytes.
+// When size is unknown the rule limiting size applies only if it asks, so huge torrent isn't accepted unseen.
+func (r *acceptRule) matches(pred *classr.TypePrediction, size int64) bool {
+	if r.Category != "" && stringToDlType(r.Category) != stringToDlType(pred.Type) {
+		return false
+	}
+	if size == 0 && (r.MinSizeGB > 0 || r.MaxSizeGB > 0) {
+		return strings.ToLower(r.Action) == "ask"
+	}
+	if r.MinSizeGB > 0 && float64(size) < r.MinSizeGB*bytesInGB {
+		return false
+	}
+	if r.MaxSizeGB > 0 && float64(size) > r.MaxSizeGB*bytesInGB {
+		return false
+	}
+	if strings.ToLower(r.Action) == "accept" && pred.Confidence < r.MinConfidence {
+		return false
+	}
+	return true
+}
+
+// decideCategory checks if prediction could be accepted without asking user.
+// size is the total size of torrent content in bytes, it is 0 when unknown.
+func decideCategory(pred *classr.TypePrediction, size int64, app *application) categoryDecision {
+	s := app.settings()
+	for _, r := range s.acceptRules {
+		if r.matches(pred, size) {
+			return categoryDecision{
+				strings.ToLower(r.Action) == "accept",
+				fmt.Sprintf("rule '%s'", r.Name),
+			}
+		}
+	}
+	dlType := stringToDlType(pred.Type)
+	if th, ok := s.catTholds[dlType]; ok {
+		return categoryDecision{
+			uint8(pred.Confidence*100) >= clampThold(th),
+			fmt.Sprintf("%s threshold %d%%", dlType.String(), clampThold(th)),
+		}
+	}
+	return categoryDecision{
+		uint8(pred.Confidence*100) >= clampThold(s.confThold),
+		fmt.Sprintf("global threshold %d%%", clampThold(s.confThold)),
+	}
+}
+
+// catTholdsFromConfig converts category names used in config to downloadType keys.
+func catTholdsFromConfig(tholds map[string]uint8) map[downloadType]uint8 {
+	result := map[downloadType]uint8{}
+	for k, v := range tholds {
+		if t := stringToDlType(k); t != unknown {
+			result[t] = v
+		}
+	}
+	return result
+}
+
+func clampThold(th uint8) uint8 {
+	if th > 100 {
+		return 100
+	}
+	return th
+}
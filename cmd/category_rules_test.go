@@ -0,0 +1,35 @@
+package main
+
+import (
+	"n2bot/classr"
+	"testing"
+)
+
+func TestDecideCategory(t *testing.T) {
+	huge := acceptRule{Name: "huge torrents", MinSizeGB: 50, Action: "ask"}
+	smallFilms := acceptRule{Name: "small films", Category: "movies", MaxSizeGB: 10, Action: "accept"}
+	series := &classr.TypePrediction{Type: "series", Confidence: 0.9}
+	film := &classr.TypePrediction{Type: "movies", Confidence: 0.1}
+	tests := []struct {
+		name   string
+		rules  []acceptRule
+		pred   *classr.TypePrediction
+		size   int64
+		accept bool
+		reason string
+	}{
+		{"huge", []acceptRule{huge}, series, 60 * bytesInGB, false, "rule 'huge torrents'"},
+		{"below huge", []acceptRule{huge}, series, bytesInGB, true, "global threshold 50%"},
+		{"unknown size asks", []acceptRule{huge}, series, 0, false, "rule 'huge torrents'"},
+		{"small film", []acceptRule{smallFilms}, film, bytesInGB, true, "rule 'small films'"},
+		{"unknown size isn't accepted", []acceptRule{smallFilms}, film, 0, false, "global threshold 50%"},
+	}
+	for _, tt := range tests {
+		app := &application{}
+		app.current.Store(newSettings(config{ConfThold: 50, AcceptRules: tt.rules}))
+		d := decideCategory(tt.pred, tt.size, app)
+		if d.accept != tt.accept || d.reason != tt.reason {
+			t.Errorf("%s: got %+v; want %v %s", tt.name, d, tt.accept, tt.reason)
+		}
+	}
+}
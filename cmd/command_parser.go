@@ -11,11 +11,24 @@ type botTask struct {
 	DlSubdir   string
 	KillGID    string
 	Magnet     string
+	History    bool
+	Stats      bool
+	All        bool
+	Backup     bool
+	Proxy      string
+	Errors     bool
+	Reload     bool
+	PauseGID   string
+	ResumeGID  string
+	WebLogin   bool
 }
 type callbackTask struct {
 	DlType     string
 	GID        string
 	CallbackID string
+	// Dup is the choice made for duplicate torrent identified by Hash.
+	Dup  string
+	Hash string
 }
 
 // ParseIncomingMessage gets all the known to the bot flags from provided text.
@@ -32,6 +45,28 @@ func ParseIncomingMessage(text string) *botTask {
 			re := regexp.MustCompile(`magnet:\?\S+`)
 			return fmt.Sprintf("%s", re.Find([]byte(text)))
 		}(),
+		flagMatcher(text, "/history", "—history", "--history"),
+		flagMatcher(text, "/stats", "—stats", "--stats"),
+		func() bool {
+			// Bare "all" is only taken right after the command, torrent names and other text could have it.
+			re := regexp.MustCompile(`(^|\s)(/|—|--)(history|stats)\s+all($|\s)`)
+			return re.MatchString(text) || flagMatcher(text, "—all", "--all")
+		}(),
+		flagMatcher(text, "/backup", "—backup", "--backup"),
+		func() string {
+			// Proxy URL contains the characters keyMatcher doesn't allow in values.
+			re := regexp.MustCompile(`(^|\s)(-p[=:]|—proxy\s|--proxy[=\s])\s?(\S+)`)
+			smch := re.FindSubmatch([]byte(text))
+			if len(smch) > 3 {
+				return fmt.Sprintf("%s", smch[3])
+			}
+			return ""
+		}(),
+		flagMatcher(text, "/errors", "—errors", "--errors"),
+		flagMatcher(text, "/reload", "—reload", "--reload"),
+		keyMatcher(text, "—pause", "--pause"),
+		keyMatcher(text, "—resume", "--resume"),
+		flagMatcher(text, "/weblogin", "—weblogin", "--weblogin"),
 	}
 }
 
@@ -42,6 +77,8 @@ func ParseCallbackQuery(text string) *callbackTask {
 		keyMatcher(text, "-t="),
 		keyMatcher(text, "-gid="),
 		keyMatcher(text, "-query_id="),
+		keyMatcher(text, "-dup="),
+		keyMatcher(text, "-hash="),
 	}
 }
 
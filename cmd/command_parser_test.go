@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestParseAll(t *testing.T) {
+	tests := map[string]bool{
+		"/stats all":                 true,
+		"/history all":               true,
+		"/stats --all":               true,
+		"—all /history":              true,
+		"/stats":                     false,
+		"/history of all":            false,
+		"magnet:?xt=urn:btih:ff all": false,
+		"-d=all /stats":              false,
+		"/history allowed":           false,
+	}
+	for text, want := range tests {
+		if got := ParseIncomingMessage(text).All; got != want {
+			t.Errorf("%q: All = %v; want %v", text, got, want)
+		}
+	}
+}
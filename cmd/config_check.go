@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"n2bot/logger"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Environment variables overriding secrets, so they could be kept out of config file.
+const (
+	envTgToken     = "N2BOT_TG_TOKEN"
+	envAria2Secret = "N2BOT_ARIA2_SECRET"
+	envAPIToken    = "N2BOT_API_TOKEN"
+)
+
+// applyEnvOverrides sets secrets from environment variables when they are set.
+func applyEnvOverrides(cfg *config) {
+	if v := os.Getenv(envTgToken); v != "" {
+		cfg.TgClientConfig.Token = v
+	}
+	if v := os.Getenv(envAria2Secret); v != "" {
+		cfg.AriaConfig.Secret = v
+	}
+	if v := os.Getenv(envAPIToken); v != "" {
+		cfg.API.Token = v
+	}
+}
+
+// configErrors are all the problems found in config, each prefixed with its TOML key path.
+type configErrors []string
+
+func (e *configErrors) add(key, format string, args ...interface{}) {
+	*e = append(*e, key+": "+fmt.Sprintf(format, args...))
+}
+
+func (e configErrors) Error() string {
+	if len(e) == 1 {
+		return "invalid config: " + e[0]
+	}
+	return fmt.Sprintf("invalid config, %d problems:\n  %s", len(e), strings.Join(e, "\n  "))
+}
+
+// validateConfig checks the whole config and reports all the problems at once.
+// Returns nil or configErrors.
+func validateConfig(cfg *config) error {
+	var errs configErrors
+
+	if cfg.TgClientConfig.Token == "" {
+		errs.add("tgClient.token", "is empty, set it in config or with %s", envTgToken)
+	}
+	if len(cfg.Users) == 0 {
+		errs.add("users", "is empty, nobody could use the bot")
+	}
+	users := map[string]bool{}
+	for _, u := range cfg.Users {
+		users[u] = true
+	}
+	for i, a := range cfg.Admins {
+		if users[a] == false {
+			errs.add(fmt.Sprintf("admins[%d]", i), "%q isn't in users", a)
+		}
+	}
+	if cfg.ConfThold > 100 {
+		errs.add("confThold", "%d is above 100", cfg.ConfThold)
+	}
+	for cat, th := range cfg.CatTholds {
+		key := "confTholds." + cat
+		if stringToDlType(cat) == unknown {
+			errs.add(key, "unknown category")
+		}
+		if th > 100 {
+			errs.add(key, "%d is above 100", th)
+		}
+	}
+	for i, r := range cfg.AcceptRules {
+		key := fmt.Sprintf("acceptRules[%d]", i)
+		switch strings.ToLower(r.Action) {
+		case "ask", "accept":
+		default:
+			errs.add(key+".action", "unknown action %q, expected \"ask\" or \"accept\"", r.Action)
+		}
+		if r.Category != "" && stringToDlType(r.Category) == unknown {
+			errs.add(key+".category", "unknown category %q", r.Category)
+		}
+		if r.MinConfidence < 0 || r.MinConfidence > 1 {
+			errs.add(key+".minConfidence", "%v is out of 0 to 1", r.MinConfidence)
+		}
+		if r.MaxSizeGB != 0 && r.MaxSizeGB < r.MinSizeGB {
+			errs.add(key+".maxSizeGB", "is less than minSizeGB")
+		}
+	}
+	for key, dir := range map[string]string{
+		"downloadDirectories.movies":  cfg.Dirs.Movies,
+		"downloadDirectories.series":  cfg.Dirs.Series,
+		"downloadDirectories.general": cfg.Dirs.General,
+	} {
+		if dir == "" {
+			errs.add(key, "is empty")
+			continue
+		}
+		fi, err := os.Stat(dir)
+		switch {
+		case os.IsNotExist(err):
+			errs.add(key, "%s doesn't exist", dir)
+		case err != nil:
+			errs.add(key, "%s", err)
+		case fi.IsDir() == false:
+			errs.add(key, "%s isn't a directory", dir)
+		}
+	}
+	for cat, dp := range cfg.DownloadProxies {
+		key := "downloadProxies." + cat
+		if cat != "default" && stringToDlType(cat) == unknown {
+			errs.add(key, "unknown category")
+		}
+		if _, err := proxyOptions(dp.AllProxy, "", ""); err != nil {
+			errs.add(key+".allProxy", "%s", err)
+		}
+		if _, err := proxyOptions(dp.HTTPProxy, "", ""); err != nil {
+			errs.add(key+".httpProxy", "%s", err)
+		}
+	}
+	if err := checkURL(cfg.AriaConfig.Aria2RPCURL); err != nil {
+		errs.add("ariaClient.aria2rpcURL", "%s", err)
+	}
+	if err := checkURL(cfg.ClassrConfig.URL); err != nil {
+		errs.add("classificator.url", "%s", err)
+	}
+	for i, p := range cfg.ProxyConfig.Proxies {
+		u, err := url.Parse(p)
+		if err != nil {
+			errs.add(fmt.Sprintf("proxyConfig.proxies[%d]", i), "%s", err)
+			continue
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			errs.add(fmt.Sprintf("proxyConfig.proxies[%d]", i), "unsupported scheme %q", u.Scheme)
+		}
+	}
+	for i, name := range cfg.ProxyConfig.UseFor {
+		switch name {
+		case "telegram", "aria2", "classificator":
+		default:
+			errs.add(fmt.Sprintf("proxyConfig.useFor[%d]", i), "unknown client %q", name)
+		}
+	}
+	switch cfg.StorageConfig.BackendType {
+	case "level", "bolt", "sqlite", "memory":
+	default:
+		errs.add("storageConfig.backendType", "%q isn't one of \"level\", \"bolt\", \"sqlite\" or \"memory\"",
+			cfg.StorageConfig.BackendType)
+	}
+	switch cfg.LogConfig.Format {
+	case "", "text", "json":
+	default:
+		errs.add("log.format", "%q isn't \"text\" or \"json\"", cfg.LogConfig.Format)
+	}
+	if cfg.LogConfig.Level != "" {
+		if _, err := logger.ParseLevel(cfg.LogConfig.Level); err != nil {
+			errs.add("log.level", "unknown level %q", cfg.LogConfig.Level)
+		}
+	}
+	for component, lvl := range cfg.LogConfig.Levels {
+		if _, err := logger.ParseLevel(lvl); err != nil {
+			errs.add("log.levels."+component, "unknown level %q", lvl)
+		}
+	}
+	if cfg.API.Listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.API.Listen); err != nil {
+			errs.add("api.listen", "%s", err)
+		}
+	}
+	if cfg.Web.Enabled {
+		if cfg.API.Listen == "" {
+			errs.add("web.enabled", "dashboard is served on api.listen which is empty")
+		}
+		if cfg.Web.BaseURL == "" {
+			errs.add("web.baseURL", "is empty, login links need the address browsers reach the bot at")
+		} else if err := checkURL(cfg.Web.BaseURL); err != nil {
+			errs.add("web.baseURL", "%s", err)
+		}
+	}
+	if cfg.ErrorReport.DigestHour > 23 {
+		errs.add("errorReport.digestHour", "%d is out of 0 to 23", cfg.ErrorReport.DigestHour)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	// Map iteration order is random, sorting keeps the report stable between runs.
+	sort.Strings(errs)
+	return errs
+}
+
+// checkURL reports the URL which couldn't be requested, empty one means the default is used.
+func checkURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q isn't an absolute HTTP URL", raw)
+	}
+	return nil
+}
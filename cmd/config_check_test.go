@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestValidateConfigReportsAll(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var cfg config
+	_, err := toml.Decode(fmt.Sprintf(`
+confThold = 120
+users = ["1"]
+admins = ["2"]
+[[acceptRules]]
+action = "maybe"
+[downloadDirectories]
+movies = "%s"
+series = "%s"
+general = "%s"
+[proxyConfig]
+proxies = ["socks5h://127.0.0.1:1080", "ftp://127.0.0.1"]
+[storageConfig]
+backendType = "mongo"
+`, filepath.Join(dir, "missing"), file, dir), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validateConfig(&cfg)
+	var errs configErrors
+	if errors.As(err, &errs) == false {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []string{
+		"acceptRules[0].action",
+		"admins[0]",
+		"confThold",
+		"downloadDirectories.movies: " + filepath.Join(dir, "missing") + " doesn't exist",
+		"downloadDirectories.series: " + file + " isn't a directory",
+		"proxyConfig.proxies[1]",
+		"storageConfig.backendType",
+		"tgClient.token",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), errs)
+	}
+	for i, key := range want {
+		if strings.HasPrefix(errs[i], key) == false {
+			t.Errorf("problem %d is %q, expected %s", i, errs[i], key)
+		}
+	}
+
+	t.Setenv(envTgToken, "123:abc")
+	applyEnvOverrides(&cfg)
+	if cfg.TgClientConfig.Token != "123:abc" {
+		t.Error("token isn't taken from environment")
+	}
+}
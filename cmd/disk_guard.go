@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"n2bot/tg"
+	"sort"
+	"time"
+)
+
+// diskGuardConfig keeps downloads from filling up download directories filesystems.
+type diskGuardConfig struct {
+	// ReserveGB is the space left free on top of the torrent size.
+	// Downloads which don't fit are queued until there is room.
+	// ReserveGB defaults to 5 when 0.
+	ReserveGB uint
+	// WarnBelowGB is the free space below which admins are warned while downloads are running.
+	// WarnBelowGB defaults to 10 when 0.
+	WarnBelowGB uint
+}
+
+// diskCheckInterval is the time between starting queued downloads and checking free space.
+const diskCheckInterval = time.Minute
+
+// errNoRoom is returned when the download doesn't fit its directory filesystem.
+var errNoRoom = errors.New("not enough free space")
+
+func reserveBytes(cfg *diskGuardConfig) uint64 {
+	if cfg.ReserveGB == 0 {
+		return 5 << 30
+	}
+	return uint64(cfg.ReserveGB) << 30
+}
+
+func warnBelowBytes(cfg *diskGuardConfig) uint64 {
+	if cfg.WarnBelowGB == 0 {
+		return 10 << 30
+	}
+	return uint64(cfg.WarnBelowGB) << 30
+}
+
+// checkRoom returns errNoRoom if the filesystem of dir has less than size plus reserve free.
+func checkRoom(dir string, size int64, cfg *diskGuardConfig) error {
+	free, err := freeSpace(existingParent(dir))
+	if err != nil {
+		return err
+	}
+	reserve := reserveBytes(cfg)
+	if free < uint64(size)+reserve {
+		return fmt.Errorf("%w in %s: %s is free, %s is needed and %s is kept in reserve",
+			errNoRoom,
+			dir,
+			humanBytes(int64(free)),
+			humanBytes(size),
+			humanBytes(int64(reserve)),
+		)
+	}
+	return nil
+}
+
+// queueForSpace keeps the task until watchDiskSpace finds room for it.
+// The owner is told once, retries of the queued task are silent.
+func queueForSpace(dInfo *downloadTaskInfo, owner, gid string, reason error, app *application) {
+	if dInfo.TaskStage == stageAwaitingSpace {
+		return
+	}
+	dInfo.TaskStage = stageAwaitingSpace
+	dInfo.Queued = time.Now()
+	if err := app.tasks.save(owner, gid, dInfo); err != nil {
+		app.log.Error("failed to save task", "owner", owner, "gid", gid, "error", err)
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			owner,
+			err.Error(),
+		)
+		return
+	}
+	app.log.Warn("download queued", "owner", owner, "gid", gid, "reason", reason)
+	app.tgClient.GetOutChan() <- tg.NewTextMessage(
+		owner,
+		fmt.Sprintf("Download of '%s' is queued, %s. It starts once there is room, send -k=%s to cancel it.",
+			dInfo.BTName,
+			reason,
+			gid,
+		),
+	)
+}
+
+// watchDiskSpace starts queued downloads once there is room for them
+// and warns admins when free space runs low while downloads are running.
+// Watching stops when ctx is done.
+func watchDiskSpace(ctx context.Context, app *application) {
+	go func() {
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+		// low holds the directories admins are warned about, they are warned again after space recovers.
+		low := map[string]bool{}
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			tasks, err := app.tasks.all()
+			if err != nil {
+				app.log.Error("failed to load tasks", "error", err)
+				continue
+			}
+			startQueued(ctx, tasks, app)
+			warnLowSpace(tasks, low, app)
+		}
+	}()
+}
+
+// startQueued tries to start the oldest queued download.
+// Only one is started at a time, free space reported by filesystem lags behind the download just started.
+func startQueued(ctx context.Context, tasks map[string]map[string]downloadTaskInfo, app *application) {
+	type queued struct {
+		owner, gid string
+		task       downloadTaskInfo
+	}
+	queue := []queued{}
+	for owner, byGID := range tasks {
+		for gid, t := range byGID {
+			if t.TaskStage == stageAwaitingSpace {
+				queue = append(queue, queued{owner, gid, t})
+			}
+		}
+	}
+	if len(queue) == 0 {
+		return
+	}
+	// aria2 GIDs aren't ordered, so downloads are started in the order they were queued.
+	sort.Slice(queue, func(i, j int) bool {
+		return queue[i].task.Queued.Before(queue[j].task.Queued)
+	})
+	q := queue[0]
+	startBTDownload(ctx, &q.task, q.owner, q.gid, app)
+}
+
+// warnLowSpace warns admins about category directories with free space below the threshold
+// if there are downloads running into them.
+func warnLowSpace(tasks map[string]map[string]downloadTaskInfo, low map[string]bool, app *application) {
+	s := app.settings()
+	warnBelow := warnBelowBytes(&s.loaded.DiskGuard)
+	dirs := map[string]bool{}
+	for _, byGID := range tasks {
+		for _, t := range byGID {
+			if t.TaskStage == stageBTDownload {
+				dirs[fullDlPath(t.DLType, "", s.dirs)] = true
+			}
+		}
+	}
+	for dir := range dirs {
+		free, err := freeSpace(existingParent(dir))
+		if err != nil {
+			app.log.Warn("failed to check free space", "dir", dir, "error", err)
+			continue
+		}
+		if free >= warnBelow {
+			delete(low, dir)
+			continue
+		}
+		if low[dir] {
+			continue
+		}
+		low[dir] = true
+		app.log.Warn("free space is low", "dir", dir, "free", free)
+		sendToChats(app, s.admins, fmt.Sprintf("Only %s is free in %s, downloads into it are still running.",
+			humanBytes(int64(free)),
+			dir,
+		))
+	}
+}
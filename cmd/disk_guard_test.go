@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"n2bot/storage"
+	"n2bot/tg"
+	"testing"
+)
+
+func TestCheckRoom(t *testing.T) {
+	dir := t.TempDir()
+	free, err := freeSpace(dir)
+	if err != nil {
+		t.Skip(err)
+	}
+	if free < 2<<30 {
+		t.Skip("less than 2 GB free in temp directory")
+	}
+	cfg := &diskGuardConfig{ReserveGB: 1}
+	if err = checkRoom(dir+"/not/created/yet", 0, cfg); err != nil {
+		t.Errorf("empty download doesn't fit: %v", err)
+	}
+	if err = checkRoom(dir, int64(free), cfg); errors.Is(err, errNoRoom) == false {
+		t.Errorf("download leaving no reserve: got %v", err)
+	}
+}
+
+func TestWarnLowSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := freeSpace(dir); err != nil {
+		t.Skip(err)
+	}
+	app := &application{
+		tgClient: tg.NewClient(&tg.Config{}),
+		tasks:    &taskStore{db: storage.NewMemory()},
+	}
+	// Any filesystem has less than the threshold free.
+	app.current.Store(newSettings(config{
+		Admins:    []string{"1"},
+		Dirs:      downloadDirectories{Movies: dir, Series: dir, General: dir},
+		DiskGuard: diskGuardConfig{WarnBelowGB: 1 << 30},
+	}))
+	tasks := map[string]map[string]downloadTaskInfo{
+		"2": {"gid1": {TaskStage: stageBTDownload, DLType: movies}},
+	}
+	low := map[string]bool{}
+	warnLowSpace(tasks, low, app)
+	if low[appendSlash(dir)] == false {
+		t.Fatal("low free space isn't noticed")
+	}
+	tasks["2"]["gid1"] = downloadTaskInfo{TaskStage: stageSeeding, DLType: movies}
+	warnLowSpace(tasks, low, app)
+	if low[appendSlash(dir)] == false {
+		t.Error("directory without running downloads is forgotten before space recovered")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// freeSpace isn't supported on this platform.
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("free space check isn't supported on this platform")
+}
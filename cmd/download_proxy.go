@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"n2bot/ariactr"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// proxyFromPool is the --proxy value to take the proxy from proxyurl pool.
+	proxyFromPool = "pool"
+	// proxyOff is the --proxy value to skip configured proxies for the download.
+	proxyOff = "off"
+)
+
+// downloadProxy is the proxy aria2 downloads the files through.
+// aria2 supports plain HTTP proxies only.
+type downloadProxy struct {
+	// AllProxy is the proxy URL used for all protocols.
+	AllProxy string
+	// HTTPProxy is the proxy URL used for HTTP downloads, overrides AllProxy for them.
+	HTTPProxy string
+	// User and Passwd are the proxy credentials if they aren't set in URL.
+	User   string
+	Passwd string
+	// UsePool takes the proxy from proxyurl pool when AllProxy is empty.
+	UsePool bool
+}
+
+// downloadProxiesFromConfig maps download proxies to categories.
+// Proxy set with "default" key is kept for unknown category and used when category has none.
+func downloadProxiesFromConfig(proxies map[string]downloadProxy) map[downloadType]downloadProxy {
+	result := map[downloadType]downloadProxy{}
+	for k, v := range proxies {
+		if k == "default" {
+			result[unknown] = v
+			continue
+		}
+		if t := stringToDlType(k); t != unknown {
+			result[t] = v
+		}
+	}
+	return result
+}
+
+// proxyCredentials keeps the credentials of proxies set with --proxy flag in memory only,
+// stored tasks have the proxy URL without them. The zero value is ready to use.
+type proxyCredentials struct {
+	mu    sync.Mutex
+	byURL map[string]*url.Userinfo
+}
+
+// strip returns --proxy flag value without credentials and remembers them for the owner.
+func (c *proxyCredentials) strip(owner, proxy string) string {
+	u := parseFlagProxy(proxy)
+	if u == nil || u.User == nil {
+		return proxy
+	}
+	userinfo := u.User
+	u.User = nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byURL == nil {
+		c.byURL = map[string]*url.Userinfo{}
+	}
+	c.byURL[owner+" "+u.String()] = userinfo
+	return u.String()
+}
+
+// get returns the credentials of the owner's proxy, ok is false if they are unknown.
+func (c *proxyCredentials) get(owner, proxy string) (user, passwd string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	userinfo, ok := c.byURL[owner+" "+proxy]
+	if ok == false {
+		return "", "", false
+	}
+	passwd, _ = userinfo.Password()
+	return userinfo.Username(), passwd, true
+}
+
+// redactProxy returns --proxy flag value without credentials.
+func redactProxy(proxy string) string {
+	u := parseFlagProxy(proxy)
+	if u == nil || u.User == nil {
+		return proxy
+	}
+	u.User = nil
+	return u.String()
+}
+
+// parseFlagProxy parses --proxy flag value, nil is returned for "pool", "off" and invalid URLs.
+func parseFlagProxy(proxy string) *url.URL {
+	if proxy == "" || proxy == proxyOff || proxy == proxyFromPool {
+		return nil
+	}
+	if strings.Contains(proxy, "://") == false {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// downloadOptions resolves aria2 options for the owner's task.
+// Proxy set with --proxy flag takes precedence over the one configured for the category.
+func downloadOptions(dInfo *downloadTaskInfo, owner string, app *application) (*ariactr.DownloadOptions, error) {
+	switch dInfo.Proxy {
+	case proxyOff:
+		return nil, nil
+	case proxyFromPool:
+		return poolOptions(app)
+	case "":
+	default:
+		user, passwd, ok := app.proxyCreds.get(owner, dInfo.Proxy)
+		if ok == false {
+			// Credentials kept in memory are lost on restart, the ones of the same proxy in config are used then.
+			user, passwd = configuredCredentials(dInfo.Proxy, app)
+		}
+		return proxyOptions(dInfo.Proxy, user, passwd)
+	}
+
+	proxies := app.settings().downloadProxies
+	dp, ok := proxies[dInfo.DLType]
+	if ok == false {
+		dp, ok = proxies[unknown]
+	}
+	if ok == false {
+		return nil, nil
+	}
+	if dp.AllProxy == "" && dp.UsePool {
+		return poolOptions(app)
+	}
+	opts, err := proxyOptions(dp.AllProxy, dp.User, dp.Passwd)
+	if err != nil {
+		return nil, err
+	}
+	if dp.HTTPProxy != "" {
+		if opts == nil {
+			opts = &ariactr.DownloadOptions{}
+		}
+		opts.HTTPProxy = dp.HTTPProxy
+		opts.HTTPProxyUser = dp.User
+		opts.HTTPProxyPasswd = dp.Passwd
+	}
+	return opts, nil
+}
+
+// configuredCredentials returns the credentials of [downloadProxies] entry with the same proxy host.
+func configuredCredentials(proxy string, app *application) (user, passwd string) {
+	u := parseFlagProxy(proxy)
+	if u == nil {
+		return "", ""
+	}
+	for _, dp := range app.settings().downloadProxies {
+		for _, p := range []string{dp.AllProxy, dp.HTTPProxy} {
+			if c := parseFlagProxy(p); c != nil && c.Host == u.Host {
+				if c.User != nil {
+					passwd, _ = c.User.Password()
+					return c.User.Username(), passwd
+				}
+				return dp.User, dp.Passwd
+			}
+		}
+	}
+	return "", ""
+}
+
+func poolOptions(app *application) (*ariactr.DownloadOptions, error) {
+	if app.proxyPool == nil {
+		return nil, errors.New("proxy pool isn't configured")
+	}
+	u := app.proxyPool.PickHTTP()
+	if u == nil {
+		return nil, errors.New("there is no healthy HTTP proxy in pool")
+	}
+	return proxyOptions(u.String(), "", "")
+}
+
+// proxyOptions validates the proxy URL and moves credentials set in it to separate options.
+func proxyOptions(rawURL, user, passwd string) (*ariactr.DownloadOptions, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	if strings.Contains(rawURL, "://") == false {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("proxy %q isn't supported, aria2 accepts HTTP proxies only", u.Redacted())
+	}
+	if u.User != nil {
+		user = u.User.Username()
+		passwd, _ = u.User.Password()
+		u.User = nil
+	}
+	return &ariactr.DownloadOptions{
+		AllProxy:       u.String(),
+		AllProxyUser:   user,
+		AllProxyPasswd: passwd,
+	}, nil
+}
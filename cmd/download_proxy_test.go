@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestProxyCredentials(t *testing.T) {
+	app := &application{}
+	app.current.Store(newSettings(config{DownloadProxies: map[string]downloadProxy{
+		"movies": {AllProxy: "http://proxy:3128", User: "cfg", Passwd: "cfgpass"},
+	}}))
+	stored := app.proxyCreds.strip("1", "user:secret@other:8080")
+	if stored != "http://other:8080" {
+		t.Fatalf("stored proxy = %q", stored)
+	}
+	opts, err := downloadOptions(&downloadTaskInfo{Proxy: stored}, "1", app)
+	if err != nil || opts.AllProxyUser != "user" || opts.AllProxyPasswd != "secret" {
+		t.Errorf("remembered credentials: %+v %v", opts, err)
+	}
+	// Other users and the bot restarted don't know the credentials, config ones are used if any.
+	opts, err = downloadOptions(&downloadTaskInfo{Proxy: stored}, "2", app)
+	if err != nil || opts.AllProxyUser != "" {
+		t.Errorf("credentials leaked to other user: %+v %v", opts, err)
+	}
+	opts, err = downloadOptions(&downloadTaskInfo{Proxy: "http://proxy:3128"}, "2", app)
+	if err != nil || opts.AllProxyUser != "cfg" || opts.AllProxyPasswd != "cfgpass" {
+		t.Errorf("configured credentials: %+v %v", opts, err)
+	}
+	if p := app.proxyCreds.strip("1", proxyFromPool); p != proxyFromPool {
+		t.Errorf("pool is stored as %q", p)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"n2bot/storage"
+	"n2bot/tg"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Choices offered for the duplicate torrent.
+const (
+	dupRedownload = "redownload"
+	dupAttach     = "attach"
+	dupCancel     = "cancel"
+)
+
+// duplicateOfferTTL is the time the magnet offered to download again is kept for.
+const duplicateOfferTTL = time.Hour
+
+// duplicateOffers holds the magnets of duplicate torrents while their senders decide what to do with them.
+// Callback data is limited to 64 bytes, so buttons carry the infohash and the magnet is kept here.
+// The zero value is ready to use.
+type duplicateOffers struct {
+	mu     sync.Mutex
+	offers map[string]duplicateOffer
+}
+
+type duplicateOffer struct {
+	task    botTask
+	expires time.Time
+}
+
+func (o *duplicateOffers) put(owner, infohash string, task *botTask) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	if o.offers == nil {
+		o.offers = map[string]duplicateOffer{}
+	}
+	for k, offer := range o.offers {
+		if now.After(offer.expires) {
+			delete(o.offers, k)
+		}
+	}
+	o.offers[owner+"/"+infohash] = duplicateOffer{*task, now.Add(duplicateOfferTTL)}
+}
+
+// take returns the offered task and forgets it, false is returned if it has expired.
+func (o *duplicateOffers) take(owner, infohash string) (botTask, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	offer, ok := o.offers[owner+"/"+infohash]
+	delete(o.offers, owner+"/"+infohash)
+	if ok == false || time.Now().After(offer.expires) {
+		return botTask{}, false
+	}
+	return offer.task, true
+}
+
+// errDuplicate is returned for the magnet of the torrent which is already downloading or was downloaded.
+var errDuplicate = errors.New("duplicate torrent")
+
+// duplicateError tells where the duplicate torrent is.
+// Question and buttons offer the choices in chat.
+type duplicateError struct {
+	infohash    string
+	description string
+	question    string
+	buttons     []tg.InlineButton
+}
+
+func (e *duplicateError) Error() string {
+	return e.description
+}
+
+func (e *duplicateError) Is(target error) bool {
+	return target == errDuplicate
+}
+
+// enqueueUniqueMagnet enqueues the magnet unless its torrent is among the tasks of all users or in history,
+// *duplicateError is returned then.
+func enqueueUniqueMagnet(ctx context.Context, owner string, task *botTask, app *application) (string, error) {
+	dup, err := findDuplicate(owner, task.Magnet, app)
+	if err != nil {
+		// Failed lookup doesn't hold up the download, aria2 refuses the torrent it already has anyway.
+		app.log.Error("failed to look for duplicates", "owner", owner, "error", err)
+	}
+	if dup != nil {
+		return "", dup
+	}
+	return enqueueMagnet(ctx, owner, task, app)
+}
+
+// findDuplicate returns nil if the torrent of the magnet isn't downloading and wasn't downloaded.
+func findDuplicate(owner, magnet string, app *application) (*duplicateError, error) {
+	infohash := strings.ToLower(hashFromMagnetLink(magnet))
+	if infohash == "" {
+		return nil, nil
+	}
+	dup, err := duplicateOfTask(owner, infohash, app)
+	if err == nil && dup == nil {
+		dup, err = duplicateOfHistory(infohash, app)
+	}
+	return dup, err
+}
+
+// offerDuplicate tells the user where the torrent is and asks what to do with it.
+func offerDuplicate(owner string, task *botTask, dup *duplicateError, app *application) {
+	if len(dup.buttons) == 0 {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			owner,
+			dup.description,
+		)
+		return
+	}
+	app.offers.put(owner, dup.infohash, task)
+	app.tgClient.GetOutChan() <- tg.NewTextWithKeyboard(
+		owner,
+		dup.description+" "+dup.question,
+		dup.buttons,
+	)
+}
+
+// duplicateOfTask describes the stored task of the torrent, nil is returned if there is none.
+// aria2 refuses to add the torrent it already has, so the sender could only attach to the task.
+func duplicateOfTask(owner, infohash string, app *application) (*duplicateError, error) {
+	refs, err := app.tasks.byInfohash(infohash)
+	if err != nil || len(refs) == 0 {
+		return nil, err
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].GID < refs[j].GID
+	})
+	ref := refs[0]
+	dInfo, ok, err := app.tasks.get(ref.Owner, ref.GID)
+	if err != nil || ok == false {
+		return nil, err
+	}
+	dup := &duplicateError{infohash: infohash}
+	if ref.Owner == owner {
+		dup.description = fmt.Sprintf("You are already downloading '%s', its GID is %s.", dInfo.BTName, ref.GID)
+		return dup, nil
+	}
+	dup.description = fmt.Sprintf("'%s' is already being downloaded by another user to '%s' category.",
+		dInfo.BTName,
+		dInfo.DLType.String(),
+	)
+	if isWatcher(owner, &dInfo) {
+		dup.description += " You are notified about it already."
+		return dup, nil
+	}
+	dup.question = "Would you like to be notified about it?"
+	dup.buttons = []tg.InlineButton{
+		duplicateButton("Notify me", dupAttach, infohash),
+		duplicateButton("Cancel", dupCancel, infohash),
+	}
+	return dup, nil
+}
+
+// duplicateOfHistory describes the last complete download of the torrent, nil is returned if there is none.
+func duplicateOfHistory(infohash string, app *application) (*duplicateError, error) {
+	records, err := storage.QueryHistory(app.db, &storage.HistoryQuery{Infohash: infohash})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r.Outcome != "complete" {
+			continue
+		}
+		return &duplicateError{
+			infohash: infohash,
+			description: fmt.Sprintf("'%s' was downloaded on %s to %s.",
+				r.Name,
+				r.Finished.Format("2006-01-02"),
+				path.Join(r.Dir, r.Name),
+			),
+			question: "Download it again?",
+			buttons: []tg.InlineButton{
+				duplicateButton("Download again", dupRedownload, infohash),
+				duplicateButton("Cancel", dupCancel, infohash),
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+func duplicateButton(text, choice, infohash string) tg.InlineButton {
+	return tg.InlineButton{
+		Text:         text,
+		CallbackData: fmt.Sprintf("-dup=%s -hash=%s", choice, infohash),
+	}
+}
+
+// handleDuplicateChoice applies the choice the user made with duplicate torrent buttons.
+func handleDuplicateChoice(ctx context.Context, chatID string, cbTask *callbackTask, app *application) {
+	task, offered := app.offers.take(chatID, cbTask.Hash)
+	var message string
+	switch cbTask.Dup {
+	case dupRedownload:
+		if offered == false {
+			message = "The offer has expired, send the magnet again."
+			break
+		}
+		if _, err := enqueueMagnet(ctx, chatID, &task, app); err != nil {
+			message = err.Error()
+		}
+	case dupAttach:
+		message = attachToTask(chatID, cbTask.Hash, app)
+	case dupCancel:
+		message = "Ok, I won't download it."
+	}
+	if message == "" {
+		return
+	}
+	app.tgClient.GetOutChan() <- tg.NewTextMessage(
+		chatID,
+		message,
+	)
+}
+
+// attachToTask makes the user notified about the task downloading the torrent along with its owner.
+func attachToTask(chatID, infohash string, app *application) string {
+	refs, err := app.tasks.byInfohash(infohash)
+	if err != nil {
+		return err.Error()
+	}
+	for _, ref := range refs {
+		if ref.Owner == chatID {
+			continue
+		}
+		var name string
+		ok, err := app.tasks.update(ref.Owner, ref.GID, func(t *downloadTaskInfo) bool {
+			name = t.BTName
+			if isWatcher(chatID, t) {
+				return false
+			}
+			t.Watchers = append(t.Watchers, chatID)
+			return true
+		})
+		if err != nil {
+			return err.Error()
+		}
+		if ok {
+			return fmt.Sprintf("I'll notify you about '%s'.", name)
+		}
+	}
+	return "The download is already over."
+}
+
+func isWatcher(chatID string, dInfo *downloadTaskInfo) bool {
+	for _, w := range dInfo.Watchers {
+		if w == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyTask sends the text to the owner of the task and to the users attached to it.
+func notifyTask(owner string, dInfo *downloadTaskInfo, text string, app *application) {
+	for _, chatID := range append([]string{owner}, dInfo.Watchers...) {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			text,
+		)
+	}
+}
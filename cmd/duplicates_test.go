@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"n2bot/storage"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDuplicates(t *testing.T) {
+	app := &application{db: storage.NewMemory()}
+	app.tasks = &taskStore{db: app.db}
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	if err := app.tasks.save("1", "gid1", &downloadTaskInfo{TaskStage: stageBTDownload, MagnetHash: hash, BTName: "film"}); err != nil {
+		t.Fatal(err)
+	}
+
+	magnet := "magnet:?xt=urn:btih:" + strings.ToUpper(hash)
+
+	dup, err := findDuplicate("1", magnet, app)
+	if err != nil || dup == nil || strings.Contains(dup.Error(), "gid1") == false || len(dup.buttons) != 0 {
+		t.Errorf("own task: %+v %v", dup, err)
+	}
+	dup, err = findDuplicate("2", magnet, app)
+	if err != nil || dup == nil || len(dup.buttons) != 2 || dup.buttons[0].CallbackData != "-dup=attach -hash="+hash {
+		t.Fatalf("other user's task: %+v %v", dup, err)
+	}
+	if errors.Is(dup, errDuplicate) == false || apiErrorStatus(dup) != http.StatusConflict {
+		t.Error("duplicate isn't reported as conflict")
+	}
+	if len(dup.buttons[0].CallbackData) > 64 {
+		t.Errorf("callback data is longer than Telegram allows: %d", len(dup.buttons[0].CallbackData))
+	}
+	cb := ParseCallbackQuery(dup.buttons[0].CallbackData + " -query_id=42")
+	if cb.Dup != dupAttach || cb.Hash != hash {
+		t.Errorf("callback: %+v", cb)
+	}
+	attachToTask("2", hash, app)
+	attachToTask("2", hash, app)
+	dInfo, _, _ := app.tasks.get("1", "gid1")
+	if len(dInfo.Watchers) != 1 || dInfo.Watchers[0] != "2" {
+		t.Errorf("watchers: %v", dInfo.Watchers)
+	}
+	if dup, _ = findDuplicate("2", magnet, app); dup == nil || len(dup.buttons) != 0 {
+		t.Error("attach is offered to the attached user")
+	}
+
+	app.tasks.delete("1", "gid1")
+	storage.AddHistory(app.db, &storage.HistoryRecord{
+		Owner: "1", GID: "gid1", Name: "film", Infohash: hash, Dir: "/movies/", Finished: time.Now(), Outcome: "complete",
+	})
+	dup, err = findDuplicate("2", magnet, app)
+	if err != nil || dup == nil || strings.Contains(dup.Error(), "/movies/film") == false || len(dup.buttons) != 2 {
+		t.Errorf("history: %+v %v", dup, err)
+	}
+	if dup, err = findDuplicate("2", "magnet:?xt=urn:btih:ffffffffffffffffffffffffffffffffffffffff", app); dup != nil || err != nil {
+		t.Errorf("new torrent: %+v %v", dup, err)
+	}
+
+	app.offers.put("2", hash, &botTask{Magnet: "magnet:?xt=urn:btih:" + hash})
+	if task, ok := app.offers.take("2", hash); ok == false || task.Magnet == "" {
+		t.Error("offer is lost")
+	}
+	if _, ok := app.offers.take("2", hash); ok {
+		t.Error("offer is taken twice")
+	}
+}
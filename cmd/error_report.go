@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"n2bot/logger"
+	"n2bot/tg"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorsListLen is the number of the latest errors shown by /errors command.
+const errorsListLen = 20
+
+// errorReportConfig configures error notifications sent to admin chats.
+type errorReportConfig struct {
+	// Chats are the chat IDs errors are sent to.
+	// Chats default to admins when empty.
+	Chats []string
+	// Digest sends all the errors once a day at DigestHour instead of right away.
+	Digest bool
+	// DigestHour is the local time hour to send the digest at, 0 to 23.
+	DigestHour uint
+	// DedupMinutes is the time in minutes the same error isn't sent again.
+	// Repeats are counted and reported with the next notice.
+	// DedupMinutes defaults to 60 when 0.
+	DedupMinutes uint
+	// MaxPerHour is the number of notices sent per hour at most, the rest are counted.
+	// MaxPerHour defaults to 10 when 0.
+	MaxPerHour uint
+	// RingSize is the number of the latest errors kept for /errors command.
+	// RingSize defaults to 100 when 0.
+	RingSize uint
+}
+
+// errorReporter is the logger sink keeping the latest errors and notifying admins.
+type errorReporter struct {
+	mu         sync.Mutex
+	cfg        *errorReportConfig
+	send       func(chats []string, text string)
+	ring       []logger.Entry
+	next       int
+	lastSent   map[string]time.Time
+	repeats    map[string]int
+	sentTimes  []time.Time
+	suppressed int
+	// digest collects errors until the daily digest is sent.
+	digest map[string]*digestLine
+}
+
+type digestLine struct {
+	entry logger.Entry
+	count int
+}
+
+func newErrorReporter(cfg *errorReportConfig, send func(chats []string, text string)) *errorReporter {
+	if cfg.DedupMinutes == 0 {
+		cfg.DedupMinutes = 60
+	}
+	if cfg.MaxPerHour == 0 {
+		cfg.MaxPerHour = 10
+	}
+	if cfg.RingSize == 0 {
+		cfg.RingSize = 100
+	}
+	return &errorReporter{
+		cfg:      cfg,
+		send:     send,
+		ring:     make([]logger.Entry, 0, cfg.RingSize),
+		lastSent: map[string]time.Time{},
+		repeats:  map[string]int{},
+		digest:   map[string]*digestLine{},
+	}
+}
+
+// watchErrors routes error records to the reporter and schedules the daily digest until ctx is done.
+func watchErrors(ctx context.Context, lg *logger.Logger, r *errorReporter) {
+	lg.AddSink(slog.LevelError, r.handle)
+	if r.cfg.Digest {
+		go r.runDigest(ctx)
+	}
+}
+
+func (r *errorReporter) handle(e logger.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, e)
+	} else {
+		r.ring[r.next] = e
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	// Telegram errors couldn't be delivered over Telegram and would only loop.
+	if e.Component == "telegram" {
+		return
+	}
+	key := e.Component + "\x00" + e.Message
+	if r.cfg.Digest {
+		if dl, ok := r.digest[key]; ok {
+			dl.count++
+			dl.entry = e
+		} else {
+			r.digest[key] = &digestLine{e, 1}
+		}
+		return
+	}
+	if e.Time.Sub(r.lastSent[key]) < time.Duration(r.cfg.DedupMinutes)*time.Minute {
+		r.repeats[key]++
+		return
+	}
+	kept := r.sentTimes[:0]
+	for _, t := range r.sentTimes {
+		if e.Time.Sub(t) < time.Hour {
+			kept = append(kept, t)
+		}
+	}
+	r.sentTimes = kept
+	if len(r.sentTimes) >= int(r.cfg.MaxPerHour) {
+		r.suppressed++
+		return
+	}
+	text := "❗ " + formatEntry(e)
+	if n := r.repeats[key]; n > 0 {
+		text += fmt.Sprintf("\n(repeated %d times since the last notice)", n)
+	}
+	if r.suppressed > 0 {
+		text += fmt.Sprintf("\n(%d more errors weren't sent due to rate limit, see /errors)", r.suppressed)
+	}
+	r.lastSent[key] = e.Time
+	delete(r.repeats, key)
+	r.sentTimes = append(r.sentTimes, e.Time)
+	r.suppressed = 0
+	r.send(r.cfg.Chats, text)
+}
+
+func (r *errorReporter) runDigest(ctx context.Context) {
+	for {
+		now := time.Now()
+		at := time.Date(now.Year(), now.Month(), now.Day(), int(r.cfg.DigestHour), 0, 0, 0, now.Location())
+		if at.After(now) == false {
+			at = at.AddDate(0, 0, 1)
+		}
+		select {
+		case <-time.After(at.Sub(now)):
+		case <-ctx.Done():
+			return
+		}
+		r.sendDigest()
+	}
+}
+
+func (r *errorReporter) sendDigest() {
+	r.mu.Lock()
+	lines := make([]*digestLine, 0, len(r.digest))
+	for _, dl := range r.digest {
+		lines = append(lines, dl)
+	}
+	r.digest = map[string]*digestLine{}
+	r.mu.Unlock()
+	if len(lines) == 0 {
+		return
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].count > lines[j].count })
+	var sb strings.Builder
+	sb.WriteString("Errors for the last day:\n\n")
+	for _, dl := range lines {
+		fmt.Fprintf(&sb, "%d× %s\n\n", dl.count, formatEntry(dl.entry))
+	}
+	r.send(r.cfg.Chats, truncateMessage(sb.String()))
+}
+
+// latest returns up to n of the latest errors, the newest first.
+func (r *errorReporter) latest(n int) []logger.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := []logger.Entry{}
+	newest := r.next - 1
+	if len(r.ring) < cap(r.ring) {
+		newest = len(r.ring) - 1
+	}
+	for i := 0; i < len(r.ring) && i < n; i++ {
+		entries = append(entries, r.ring[(newest-i+len(r.ring))%len(r.ring)])
+	}
+	return entries
+}
+
+func handleErrors(chatID string, app *application) {
+	if isAdmin(chatID, app) == false {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			"Only admins could see errors.",
+		)
+		return
+	}
+	var sb strings.Builder
+	for _, e := range app.errReporter.latest(errorsListLen) {
+		sb.WriteString(formatEntry(e) + "\n\n")
+	}
+	message := sb.String()
+	if message == "" {
+		message = "No errors so far."
+	}
+	app.tgClient.GetOutChan() <- tg.NewTextMessage(
+		chatID,
+		truncateMessage(message),
+	)
+}
+
+func formatEntry(e logger.Entry) string {
+	component := e.Component
+	if component == "" {
+		component = "main"
+	}
+	line := fmt.Sprintf("%s [%s] %s", e.Time.Format("2006-01-02 15:04:05"), component, e.Message)
+	if e.Fields != "" {
+		line += " " + e.Fields
+	}
+	return line
+}
+
+// truncateMessage keeps the text within Telegram message length limit.
+func truncateMessage(text string) string {
+	const maxLen = 4000
+	if len(text) <= maxLen {
+		return text
+	}
+	return strings.ToValidUTF8(text[:maxLen], "") + "…"
+}
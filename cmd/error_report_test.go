@@ -0,0 +1,35 @@
+package main
+
+import (
+	"n2bot/logger"
+	"testing"
+	"time"
+)
+
+func TestErrorReporterDedupAndRateLimit(t *testing.T) {
+	sent := []string{}
+	r := newErrorReporter(&errorReportConfig{MaxPerHour: 2, RingSize: 3}, func(chats []string, text string) {
+		sent = append(sent, text)
+	})
+	now := time.Now()
+	for i, msg := range []string{"a", "a", "b", "c", "d"} {
+		r.handle(logger.Entry{Time: now.Add(time.Duration(i) * time.Second), Component: "aria2", Message: msg})
+	}
+	// "a" is sent once, "b" hits the limit of 2 per hour, "c" and "d" are suppressed.
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 notices, got %d: %v", len(sent), sent)
+	}
+	if r.suppressed != 2 {
+		t.Errorf("expected 2 suppressed errors, got %d", r.suppressed)
+	}
+
+	latest := r.latest(10)
+	if len(latest) != 3 {
+		t.Fatalf("expected ring of 3, got %d", len(latest))
+	}
+	for i, msg := range []string{"d", "c", "b"} {
+		if latest[i].Message != msg {
+			t.Errorf("latest[%d] is %q, expected %q", i, latest[i].Message, msg)
+		}
+	}
+}
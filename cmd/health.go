@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"n2bot/sdnotify"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// healthConfig configures /healthz and /readyz checks and systemd watchdog.
+type healthConfig struct {
+	// MinFreeSpaceGB is the free space on download directories filesystems below which the bot isn't ready.
+	// MinFreeSpaceGB defaults to 1 when 0.
+	MinFreeSpaceGB uint
+	// PollStaleAfter is the time in seconds since Telegram responded to polling the last time
+	// after which polling is reported stuck.
+	// PollStaleAfter defaults to tgClient connTimeout plus 60 seconds when 0.
+	PollStaleAfter uint
+}
+
+// healthCheck is the result of a single check.
+// Only critical checks fail readiness, the bot works without classificator asking users for categories.
+type healthCheck struct {
+	OK       bool   `json:"ok"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	OK     bool                   `json:"ok"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// checkTimeout limits every check of dependencies.
+const checkTimeout = 5 * time.Second
+
+// checkLiveness checks the bot's own loops, it is used by /healthz and systemd watchdog.
+// Dependencies are left out, restarting the bot wouldn't fix them.
+func checkLiveness(cfg *healthConfig, app *application) healthReport {
+	return newHealthReport(map[string]healthCheck{
+		"telegram": checkPolling(cfg, app),
+	})
+}
+
+// checkReadiness checks everything the bot needs to handle downloads, it is used by /readyz.
+func checkReadiness(ctx context.Context, cfg *healthConfig, app *application) healthReport {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	checks := map[string]healthCheck{
+		"telegram": checkPolling(cfg, app),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	run := func(name string, critical bool, check func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check()
+			mu.Lock()
+			checks[name] = newHealthCheck(critical, err)
+			mu.Unlock()
+		}()
+	}
+	run("aria2", true, func() error { return app.ariaClient.Ping(ctx) })
+	if app.settings().loaded.ClassrConfig.URL != "" {
+		run("classificator", false, func() error { return app.classrClient.Ping(ctx) })
+	}
+	run("storage", true, func() error {
+		_, err := app.db.Get([]byte(gidKeyPrefix))
+		return err
+	})
+	run("disk", true, func() error { return checkDiskSpace(cfg, app) })
+	wg.Wait()
+	return newHealthReport(checks)
+}
+
+func checkPolling(cfg *healthConfig, app *application) healthCheck {
+	staleAfter := time.Duration(cfg.PollStaleAfter) * time.Second
+	if staleAfter == 0 {
+		staleAfter = time.Duration(app.tgClient.ConnTimeout()+60) * time.Second
+	}
+	last := app.tgClient.LastPolled()
+	if last.IsZero() {
+		return newHealthCheck(true, fmt.Errorf("Telegram polling isn't started"))
+	}
+	if age := time.Since(last); age > staleAfter {
+		return newHealthCheck(true, fmt.Errorf("Telegram responded to polling %s ago", age.Round(time.Second)))
+	}
+	return newHealthCheck(true, nil)
+}
+
+// checkDiskSpace checks free space on the filesystems of all download directories.
+func checkDiskSpace(cfg *healthConfig, app *application) error {
+	minFree := uint64(cfg.MinFreeSpaceGB) << 30
+	if minFree == 0 {
+		minFree = 1 << 30
+	}
+	dirs := app.settings().dirs
+	for _, dir := range []string{dirs.Movies, dirs.Series, dirs.General} {
+		free, err := freeSpace(existingParent(dir))
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("%s has %s free, less than %s", dir, humanBytes(int64(free)), humanBytes(int64(minFree)))
+		}
+	}
+	return nil
+}
+
+// existingParent returns the closest existing directory of path,
+// download directories are created by aria2 with the first download.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func newHealthCheck(critical bool, err error) healthCheck {
+	if err != nil {
+		return healthCheck{false, critical, err.Error()}
+	}
+	return healthCheck{true, critical, ""}
+}
+
+func newHealthReport(checks map[string]healthCheck) healthReport {
+	report := healthReport{true, checks}
+	for _, c := range checks {
+		if c.OK == false && c.Critical {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+func writeHealthReport(w http.ResponseWriter, report healthReport) {
+	code := http.StatusOK
+	if report.OK == false {
+		code = http.StatusServiceUnavailable
+	}
+	writeAPIResult(w, code, report)
+}
+
+// notifySystemd reports readiness to systemd and pings its watchdog while the bot is live until ctx is done.
+// Nothing is sent when the bot isn't run by systemd with Type=notify.
+func notifySystemd(ctx context.Context, cfg *healthConfig, app *application) {
+	sent, err := sdnotify.Notify(sdnotify.Ready)
+	if err != nil {
+		app.log.Warn("failed to notify systemd", "error", err)
+	}
+	interval := sdnotify.WatchdogInterval()
+	if sent == false || interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			// Missed pings let systemd restart the bot stuck without Telegram updates.
+			if report := checkLiveness(cfg, app); report.OK == false {
+				app.log.Warn("bot isn't live, watchdog isn't pinged", "checks", report.Checks)
+				continue
+			}
+			if _, err := sdnotify.Notify(sdnotify.Watchdog); err != nil {
+				app.log.Warn("failed to ping systemd watchdog", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"n2bot/ariactr"
+	"n2bot/storage"
+	"n2bot/tg"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckReadiness(t *testing.T) {
+	aria := fakeAria2(t)
+	defer aria.Close()
+	ac, err := ariactr.NewClient(&ariactr.Config{Aria2RPCURL: aria.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	app := &application{
+		tgClient:   tg.NewClient(&tg.Config{}),
+		ariaClient: ac,
+		db:         storage.NewMemory(),
+	}
+	app.current.Store(newSettings(config{Dirs: downloadDirectories{
+		Movies:  filepath.Join(dir, "not", "created", "yet"),
+		Series:  dir,
+		General: dir,
+	}}))
+	cfg := &healthConfig{MinFreeSpaceGB: 1}
+
+	report := checkReadiness(context.Background(), cfg, app)
+	if report.OK {
+		t.Error("ready before Telegram polling started")
+	}
+	for _, name := range []string{"aria2", "storage", "disk"} {
+		if report.Checks[name].OK == false {
+			t.Errorf("%s check failed: %s", name, report.Checks[name].Error)
+		}
+	}
+	if _, ok := report.Checks["classificator"]; ok {
+		t.Error("classificator isn't configured but checked")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"n2bot/ariactr"
+	"n2bot/storage"
+	"n2bot/tg"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyListLen is the number of the most recent downloads shown by /history.
+const historyListLen = 15
+
+// recordHistory stores the history record of finished, failed or killed task.
+// outcome is one of "complete", "error" or "removed".
+func recordHistory(dInfo *downloadTaskInfo, statusUpd *ariactr.TaskStatus, outcome string, app *application) {
+	compLen, _ := statusUpd.CompletedLength.Int64()
+	totlLen, _ := statusUpd.TotalLength.Int64()
+	upLen, _ := statusUpd.UploadLength.Int64()
+	name := dInfo.BTName
+	if statusUpd.Bittorrent.Info.Name != "" {
+		name = statusUpd.Bittorrent.Info.Name
+	}
+	rec := storage.HistoryRecord{
+		Owner:    statusUpd.OwnerID,
+		GID:      statusUpd.GID,
+		Name:     name,
+		Infohash: strings.ToLower(dInfo.MagnetHash),
+		Category: dInfo.DLType.String(),
+		Size:     totlLen,
+		Uploaded: upLen,
+		Started:  dInfo.Started,
+		Finished: time.Now(),
+		Outcome:  outcome,
+	}
+	if dInfo.TaskStage == stageBTDownload || dInfo.TaskStage == stageSeeding {
+		rec.Dir = fullDlPath(dInfo.DLType, dInfo.DLDir, app.settings().dirs)
+	}
+	dlEnd := dInfo.Downloaded
+	if dlEnd.IsZero() {
+		dlEnd = rec.Finished
+	}
+	if dInfo.Started.IsZero() == false && dlEnd.After(dInfo.Started) {
+		rec.AvgSpeed = int64(float64(compLen) / dlEnd.Sub(dInfo.Started).Seconds())
+	}
+	if compLen > 0 {
+		rec.Ratio = float64(upLen) / float64(compLen)
+	}
+	if err := storage.AddHistory(app.db, &rec); err != nil {
+		app.log.Error("failed to record history", "owner", rec.Owner, "gid", rec.GID, "error", err)
+	}
+}
+
+func handleHistory(chatID string, all bool, app *application) {
+	if all && isAdmin(chatID, app) == false {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			"Only admins could see everyone's history.",
+		)
+		return
+	}
+	q := storage.HistoryQuery{Owner: chatID, Limit: historyListLen}
+	if all {
+		q.Owner = ""
+	}
+	records, err := storage.QueryHistory(app.db, &q)
+	if err != nil {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			err.Error(),
+		)
+		return
+	}
+	var message string
+	for _, r := range records {
+		name := r.Name
+		if len(name) > 50 {
+			name = name[:50]
+		}
+		message = fmt.Sprintf("%s%s\t%s\t%s\t%s\t%s, ratio %.2f",
+			message,
+			r.Finished.Format("2006-01-02"),
+			r.Outcome,
+			r.Category,
+			name,
+			humanBytes(r.Size),
+			r.Ratio,
+		)
+		if q.Owner == "" {
+			message = fmt.Sprintf("%s\tby %s", message, r.Owner)
+		}
+		message += "\n\n"
+	}
+	if message == "" {
+		message = "No downloads in history."
+	}
+	app.tgClient.GetOutChan() <- tg.NewTextMessage(
+		chatID,
+		message,
+	)
+}
+
+// historyTotals is the aggregate of history records.
+type historyTotals struct {
+	count int
+	size  int64
+}
+
+func handleStats(chatID string, all bool, app *application) {
+	if all && isAdmin(chatID, app) == false {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			"Only admins could see everyone's stats.",
+		)
+		return
+	}
+	q := storage.HistoryQuery{Owner: chatID}
+	if all {
+		q.Owner = ""
+	}
+	records, err := storage.QueryHistory(app.db, &q)
+	if err != nil {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			err.Error(),
+		)
+		return
+	}
+	if len(records) == 0 {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			"No downloads in history.",
+		)
+		return
+	}
+	perCategory := map[string]*historyTotals{}
+	perMonth := map[string]*historyTotals{}
+	var failed int
+	for _, r := range records {
+		if r.Outcome != "complete" {
+			failed++
+			continue
+		}
+		addTotals(perCategory, r.Category, r.Size)
+		addTotals(perMonth, r.Finished.Format("2006-01"), r.Size)
+	}
+	message := "Per category:\n" + formatTotals(perCategory) +
+		"\nPer month:\n" + formatTotals(perMonth) +
+		fmt.Sprintf("\nFailed or killed: %d", failed)
+	app.tgClient.GetOutChan() <- tg.NewTextMessage(
+		chatID,
+		message,
+	)
+}
+
+func addTotals(totals map[string]*historyTotals, key string, size int64) {
+	if totals[key] == nil {
+		totals[key] = &historyTotals{}
+	}
+	totals[key].count++
+	totals[key].size += size
+}
+
+func formatTotals(totals map[string]*historyTotals) string {
+	keys := []string{}
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var text string
+	for _, k := range keys {
+		text = fmt.Sprintf("%s%s: %d downloads, %s\n", text, k, totals[k].count, humanBytes(totals[k].size))
+	}
+	if text == "" {
+		text = "nothing yet\n"
+	}
+	return text
+}
+
+func isAdmin(chatID string, app *application) bool {
+	for _, a := range app.settings().admins {
+		if a == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+func humanBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -1,79 +1,234 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"n2bot/ariactr"
 	"n2bot/classr"
-	"n2bot/fatalist"
+	"n2bot/logger"
+	"n2bot/metrics"
 	"n2bot/proxyurl"
+	"n2bot/sdnotify"
 	"n2bot/storage"
+	"n2bot/supervisor"
 	"n2bot/tg"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
 func main() {
 	var err error
 	var cfg config
 
-	_, err = toml.DecodeFile("config.toml", &cfg)
+	dryRun := flag.Bool("dry-run", false, "keep storage in memory only, nothing is written to the database")
+	configPath := flag.String("config", "config.toml", "path to config file")
+	checkConfig := flag.Bool("check-config", false, "validate config, print all the problems found and exit")
+	printVersion := flag.Bool("version", false, "print version and exit")
+	flag.Parse()
+
+	if *printVersion {
+		fmt.Println("n2bot", version)
+		return
+	}
+	_, err = toml.DecodeFile(*configPath, &cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	applyEnvOverrides(&cfg)
+	err = validateConfig(&cfg)
+	if *checkConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("config is valid")
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Clients set defaults in their configs, so reloaded config is compared with the copy.
+	loaded := cfg
+	lg, err := logger.New(&cfg.LogConfig, os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lg.Close()
+	// Migrations and subcommands report their progress with standard log.
+	lg.RedirectStdLog()
+	mainLog := lg.For("main")
+	if flag.NArg() > 0 {
+		if err = runSubcommand(flag.Args(), &cfg); err != nil {
+			mainLog.Fatal("subcommand failed", "subcommand", flag.Arg(0), "error", err)
+		}
+		return
+	}
 
 	tc := tg.NewClient(&cfg.TgClientConfig)
 	ac, err := ariactr.NewClient(&cfg.AriaConfig)
 	if err != nil {
-		log.Fatal(err)
+		mainLog.Fatal("aria2 is unreachable", "error", err)
 	}
-	if cfg.ProxyConfig.ProxiesSource != "" {
-		proxyurl.NewTransport(&cfg.ProxyConfig).InjectIntoClient(tc.HttpClient)
+	if *dryRun {
+		mainLog.Info("dry run: storage is kept in memory only")
+		cfg.StorageConfig = storage.Config{BackendType: "memory"}
 	}
 	db, err := storage.NewInstance(&cfg.StorageConfig)
 	if err != nil {
-		log.Fatal(err)
+		mainLog.Fatal("failed to open storage", "error", err)
+	}
+	if err = runMigrations(db); err != nil {
+		mainLog.Fatal("migration failed", "error", err)
 	}
 	cc := classr.NewClient(&cfg.ClassrConfig)
 
-	fatal := fatalist.New()
+	// ctx stops polling, listening and proxy health checks on shutdown,
+	// handlers already running keep handlerCtx until shutdown times out.
+	ctx, stop := context.WithCancel(context.Background())
+	handlerCtx, abortHandlers := context.WithCancel(context.Background())
+	var pool *proxyurl.Pool
+	if cfg.ProxyConfig.ProxiesSource != "" || len(cfg.ProxyConfig.Proxies) > 0 {
+		pool = proxyurl.NewPool(&cfg.ProxyConfig)
+		pool.Run(ctx)
+		clients := map[string]*http.Client{
+			"telegram":      tc.HttpClient,
+			"aria2":         ac.HTTPClient(),
+			"classificator": cc.HTTPClient(),
+		}
+		for name, c := range clients {
+			if cfg.ProxyConfig.UsedFor(name) {
+				proxyurl.NewTransport(pool).InjectIntoClient(c)
+			}
+		}
+	}
+
 	app := application{
 		tgClient:     tc,
 		ariaClient:   ac,
 		classrClient: cc,
 		db:           db,
-		dirs:         &cfg.Dirs,
-		errHandler:   &fatal,
-		confThold:    cfg.ConfThold,
-		users:        cfg.Users,
+		tasks:        &taskStore{db: db},
+		log:          mainLog,
+		backupCfg:    &cfg.BackupConfig,
+		proxyPool:    pool,
+		configPath:   *configPath,
 	}
+	app.current.Store(newSettings(loaded))
 
-	tc.SetErrorHandler(&fatal)
-	ac.SetErrorHandler(&fatal)
-	cc.SetErrorHandler(&fatal)
+	tc.SetLogger(lg)
+	ac.SetLogger(lg)
+	cc.SetLogger(lg)
+	sup := supervisor.New(&cfg.SupervisorConfig)
+	watchComponents(sup, &app)
+	app.errReporter = newErrorReporter(&cfg.ErrorReport, func(chats []string, text string) {
+		if len(chats) == 0 {
+			chats = app.settings().admins
+		}
+		sendToChats(&app, chats, text)
+	})
+	tc.SetSupervisor(sup)
+	ac.SetSupervisor(sup)
+	if cfg.Web.Enabled {
+		web, err := newWebAuth(&cfg.Web)
+		if err != nil {
+			mainLog.Fatal("failed to set up web dashboard", "error", err)
+		}
+		app.web = web
+	}
+	if cfg.API.Metrics {
+		app.metrics = metrics.New()
+		app.metrics.SetTaskCounter(app.tasks.countByStage)
+		tc.SetMetrics(app.metrics)
+		ac.SetMetrics(app.metrics)
+		cc.SetMetrics(app.metrics)
+	}
 
+	watchErrors(ctx, lg, app.errReporter)
 	tc.Run(
+		ctx,
 		func(msg tg.ChatMessage) {
-			handleNewIncomingTask(&msg, &app)
+			handleNewIncomingTask(handlerCtx, &msg, &app)
 		},
 	)
 	ac.Run(
+		ctx,
 		func(ts ariactr.TaskStatus) {
-			handleAriaUpdates(&ts, &app)
+			handleAriaUpdates(handlerCtx, &ts, &app)
 		},
 	)
 
 	if err = pollSavedTasks(&app); err != nil {
-		log.Fatal(err)
+		mainLog.Fatal("failed to load saved tasks", "error", err)
 	}
+	runScheduledBackups(ctx, &app)
+	watchDiskSpace(ctx, &app)
+	var apiServer *http.Server
+	if cfg.API.Listen != "" {
+		if apiServer, err = serveAPI(&cfg.API, &cfg.Health, &app); err != nil {
+			mainLog.Fatal("failed to serve API", "error", err)
+		}
+	}
+
+	notifySystemd(ctx, &cfg.Health, &app)
 
-	logChan := fatal.GetLogChan()
-	fatalChan := fatal.GetFatalChan()
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	exitCode := 0
+loop:
 	for {
 		select {
-		case e := <-logChan:
-			log.Println(e)
-		case e := <-fatalChan:
-			log.Fatal(e)
+		case <-hupChan:
+			handleReload("", &app)
+		case e := <-sup.Exhausted():
+			mainLog.Error("giving up", "error", e)
+			exitCode = 1
+			break loop
+		case s := <-sigChan:
+			mainLog.Info("shutting down", "signal", s.String())
+			break loop
+		}
+	}
+	stop()
+	sdnotify.Notify(sdnotify.Stopping)
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = 10
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
+	go func() {
+		<-shutdownCtx.Done()
+		abortHandlers()
+	}()
+	if apiServer != nil {
+		if err = apiServer.Shutdown(shutdownCtx); err != nil {
+			mainLog.Warn("API requests handling didn't finish", "error", err)
 		}
 	}
+	// aria2 updates handlers reply over Telegram, so Telegram sender is stopped the last.
+	if err = ac.Wait(shutdownCtx); err != nil {
+		mainLog.Warn("aria2 updates handling didn't finish", "error", err)
+	}
+	if err = tc.Shutdown(shutdownCtx); err != nil {
+		mainLog.Warn("Telegram client didn't shut down cleanly", "error", err)
+	}
+	// Closing storage flushes in-memory snapshot if one is configured.
+	if err = db.Close(); err != nil {
+		mainLog.Error("failed to close storage", "error", err)
+		exitCode = 1
+	}
+	mainLog.Info("bye")
+	lg.Close()
+	os.Exit(exitCode)
 }
@@ -1,24 +1,26 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"n2bot/ariactr"
 	"n2bot/classr"
-	"n2bot/storage"
+	"n2bot/metrics"
 	"n2bot/tg"
+	"n2bot/torfile"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
 	"strings"
+	"time"
 )
 
-func handleNewIncomingTask(msg *tg.ChatMessage, app *application) {
+func handleNewIncomingTask(ctx context.Context, msg *tg.ChatMessage, app *application) {
 	authorized := false
-	ariaClt := app.ariaClient
 	tgClt := app.tgClient
-	db := app.db
-	for _, usr := range app.users {
+	for _, usr := range app.settings().users {
 		if usr == msg.ChatID {
 			authorized = true
 		}
@@ -31,22 +33,50 @@ func handleNewIncomingTask(msg *tg.ChatMessage, app *application) {
 		return
 	}
 	if msg.Type == tg.MessageTypeFromString("callback") {
-		handleCallback(msg, app)
+		handleCallback(ctx, msg, app)
 		return
 	}
 	task := ParseIncomingMessage(msg.Text)
 	if task.KillGID != "" {
-		handleKillTask(msg.ChatID, task.KillGID, app)
+		handleKillTask(ctx, msg.ChatID, task.KillGID, app)
 		if task.Magnet == "" {
 			return
 		}
 	}
+	if task.PauseGID != "" || task.ResumeGID != "" {
+		handlePauseTask(ctx, msg.ChatID, task.PauseGID, task.ResumeGID, app)
+		return
+	}
 	if task.TellActive {
-		handleTellActive(msg.ChatID, app)
+		handleTellActive(ctx, msg.ChatID, app)
 		if task.Magnet == "" {
 			return
 		}
 	}
+	if task.History {
+		handleHistory(msg.ChatID, task.All, app)
+		return
+	}
+	if task.Stats {
+		handleStats(msg.ChatID, task.All, app)
+		return
+	}
+	if task.Backup {
+		handleBackup(msg.ChatID, app)
+		return
+	}
+	if task.Errors {
+		handleErrors(msg.ChatID, app)
+		return
+	}
+	if task.Reload {
+		handleReload(msg.ChatID, app)
+		return
+	}
+	if task.WebLogin {
+		handleWebLogin(msg.ChatID, app)
+		return
+	}
 	if task.Magnet == "" {
 		tgClt.GetOutChan() <- tg.NewTextMessage(
 			msg.ChatID,
@@ -54,29 +84,72 @@ func handleNewIncomingTask(msg *tg.ChatMessage, app *application) {
 		)
 		return
 	}
-	gid, err := ariaClt.EnqueueMetadata(msg.ChatID, task.Magnet)
+	_, err := enqueueUniqueMagnet(ctx, msg.ChatID, task, app)
+	var dup *duplicateError
+	if errors.As(err, &dup) {
+		offerDuplicate(msg.ChatID, task, dup, app)
+		return
+	}
 	if err != nil {
 		tgClt.GetOutChan() <- tg.NewTextMessage(
 			msg.ChatID,
 			err.Error(),
 		)
-		return
 	}
+}
+
+// enqueueMagnet starts collecting metadata of the magnet and saves the task.
+// Returns GID of the metadata task.
+func enqueueMagnet(ctx context.Context, owner string, task *botTask, app *application) (string, error) {
 	dlTask := prepareMagnetInfo(task)
-	err = saveNewTask(msg.ChatID, gid, &dlTask, db)
+	dlTask.Proxy = app.proxyCreds.strip(owner, task.Proxy)
+	opts, err := downloadOptions(&dlTask, owner, app)
 	if err != nil {
-		tgClt.GetOutChan() <- tg.NewTextMessage(
-			msg.ChatID,
-			err.Error(),
-		)
+		return "", err
+	}
+	gid, err := app.ariaClient.EnqueueMetadata(ctx, owner, task.Magnet, opts)
+	if err != nil {
+		return "", err
 	}
+	if dlTask.DLType != unknown {
+		app.metrics.CategoryChosen(metrics.CategoryFromFlag)
+	}
+	return gid, app.tasks.save(owner, gid, &dlTask)
+}
+
+// enqueueURL starts the download of the file by URL to the category directory and saves the task.
+// Category couldn't be predicted for plain files, so it defaults to general.
+func enqueueURL(ctx context.Context, owner, fileURL string, task *botTask, app *application) (string, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp") {
+		return "", fmt.Errorf("%q isn't a downloadable URL", fileURL)
+	}
+	dlTask := downloadTaskInfo{
+		TaskStage: stageBTDownload,
+		DLDir:     task.DlSubdir,
+		DLType:    stringToDlType(task.DlType),
+		BTName:    path.Base(u.Path),
+		Proxy:     app.proxyCreds.strip(owner, task.Proxy),
+		Started:   time.Now(),
+	}
+	if dlTask.DLType == unknown {
+		dlTask.DLType = common
+	}
+	opts, err := downloadOptions(&dlTask, owner, app)
+	if err != nil {
+		return "", err
+	}
+	fullPath := fullDlPath(dlTask.DLType, dlTask.DLDir, app.settings().dirs)
+	gid, err := app.ariaClient.EnqueueURL(ctx, owner, fullPath, fileURL, opts)
+	if err != nil {
+		return "", err
+	}
+	return gid, app.tasks.save(owner, gid, &dlTask)
 }
 
-func handleAriaUpdates(statusUpd *ariactr.TaskStatus, app *application) {
+func handleAriaUpdates(ctx context.Context, statusUpd *ariactr.TaskStatus, app *application) {
 	tgClt := app.tgClient
-	db := app.db
-	infos, _ := getTaskInfosByUser(statusUpd.OwnerID, db)
-	dInfo, ok := infos[statusUpd.GID]
+	dInfo, ok, _ := app.tasks.get(statusUpd.OwnerID, statusUpd.GID)
 	if ok == false {
 		// tgClt.GetOutChan() <- tg.NewTextMessage(
 		// 	statusUpd.OwnerID,
@@ -86,33 +159,32 @@ func handleAriaUpdates(statusUpd *ariactr.TaskStatus, app *application) {
 	}
 
 	status := statusUpd.Status
+	compLen, _ := statusUpd.CompletedLength.Int64()
+	totlLen, _ := statusUpd.TotalLength.Int64()
+	upLen, _ := statusUpd.UploadLength.Int64()
+	app.metrics.ObserveTransfer(statusUpd.GID, compLen, upLen,
+		status == "error" || status == "removed" || status == "complete")
 	if status == "error" {
-		tgClt.GetOutChan() <- tg.NewTextMessage(
-			statusUpd.OwnerID,
-			fmt.Sprintf("Download of '%s' is failed! %s",
-				dInfo.BTName,
-				statusUpd.ErrorMessage,
-			),
-		)
-		deleteTaskInfo(statusUpd.OwnerID, statusUpd.GID, db)
+		notifyTask(statusUpd.OwnerID, &dInfo, fmt.Sprintf("Download of '%s' is failed! %s",
+			dInfo.BTName,
+			statusUpd.ErrorMessage,
+		), app)
+		recordHistory(&dInfo, statusUpd, "error", app)
+		app.tasks.delete(statusUpd.OwnerID, statusUpd.GID)
 		return
 	}
 	if status == "removed" {
-		app.tgClient.GetOutChan() <- tg.NewTextMessage(
-			statusUpd.OwnerID,
-			fmt.Sprintf("Task with GID %s removed.", statusUpd.GID),
-		)
-		deleteTaskInfo(statusUpd.OwnerID, statusUpd.GID, db)
+		notifyTask(statusUpd.OwnerID, &dInfo, fmt.Sprintf("Task with GID %s removed.", statusUpd.GID), app)
+		recordHistory(&dInfo, statusUpd, "removed", app)
+		app.tasks.delete(statusUpd.OwnerID, statusUpd.GID)
 		return
 	}
-	compLen, _ := statusUpd.CompletedLength.Int64()
-	totlLen, _ := statusUpd.TotalLength.Int64()
 	if dInfo.TaskStage == stageMagnetMeta {
 		if status == "active" {
 			tgClt.GetOutChan() <- tg.NewTyping(statusUpd.OwnerID)
 		}
 		if status == "complete" || (compLen != 0 && compLen == totlLen) {
-			handleMagnetCompletion(&dInfo, statusUpd, app)
+			handleMagnetCompletion(ctx, &dInfo, statusUpd, app)
 		}
 	}
 
@@ -121,57 +193,76 @@ func handleAriaUpdates(statusUpd *ariactr.TaskStatus, app *application) {
 			statusUpd.Bittorrent.Info.Name != "" &&
 			dInfo.BTName != statusUpd.Bittorrent.Info.Name {
 			dInfo.BTName = statusUpd.Bittorrent.Info.Name
-			saveNewTask(statusUpd.OwnerID, statusUpd.GID, &dInfo, db)
+			app.tasks.update(statusUpd.OwnerID, statusUpd.GID, func(t *downloadTaskInfo) bool {
+				t.BTName = dInfo.BTName
+				return true
+			})
 		}
 		if status == "complete" || (compLen != 0 && compLen == totlLen) {
-			if statusUpd.Bittorrent.Info.Name != "" {
-				tgClt.GetOutChan() <- tg.NewTextMessage(
-					statusUpd.OwnerID,
-					fmt.Sprintf("Download of '%s' to '%s' category is complete!",
-						statusUpd.Bittorrent.Info.Name,
-						dInfo.DLType.String()),
-				)
+			// Files downloaded by URL have no torrent name, the one taken from URL is kept.
+			name := statusUpd.Bittorrent.Info.Name
+			if name == "" {
+				name = dInfo.BTName
+			}
+			if name != "" {
+				notifyTask(statusUpd.OwnerID, &dInfo, fmt.Sprintf("Download of '%s' to '%s' category is complete!",
+					name,
+					dInfo.DLType.String(),
+				), app)
 			}
 			dInfo.TaskStage = stageSeeding
-			saveNewTask(statusUpd.OwnerID, statusUpd.GID, &dInfo, db)
+			dInfo.Downloaded = time.Now()
+			app.tasks.update(statusUpd.OwnerID, statusUpd.GID, func(t *downloadTaskInfo) bool {
+				t.TaskStage = stageSeeding
+				t.Downloaded = dInfo.Downloaded
+				return true
+			})
 		}
 	}
 
 	if dInfo.TaskStage == stageSeeding && status == "complete" {
-		deleteTaskInfo(statusUpd.OwnerID, statusUpd.GID, db)
+		recordHistory(&dInfo, statusUpd, "complete", app)
+		app.tasks.delete(statusUpd.OwnerID, statusUpd.GID)
 	}
 }
 
-func handleMagnetCompletion(dInfo *downloadTaskInfo, statusUpd *ariactr.TaskStatus, app *application) {
+func handleMagnetCompletion(ctx context.Context, dInfo *downloadTaskInfo, statusUpd *ariactr.TaskStatus, app *application) {
 	tgClt := app.tgClient
-	confTh := func() uint8 {
-		if app.confThold > 100 {
-			return 100
-		}
-		return app.confThold
-	}()
 	torrentFilename := strings.ToLower(dInfo.MagnetHash) + ".torrent"
 	if dInfo.DLType == unknown {
-		out, err := dlCategoryByTorrent(app.classrClient, torrentFilename) // ask script for some ML magic
-		if err != nil || uint8(out.Confidence*100) < confTh {
-			app.errHandler.LogError(err)
+		out, err := dlCategoryByTorrent(ctx, app.classrClient, dInfo.MagnetHash, torrentFilename) // ask script for some ML magic
+		lg := app.log.With("owner", statusUpd.OwnerID, "gid", statusUpd.GID)
+		if err != nil {
+			lg.Error("classification failed", "error", err)
+		}
+		var size int64
+		if meta, err := torfile.ReadFile(torrentFilename); err == nil {
+			size = meta.TotalLength
+		}
+		decision := decideCategory(&out, size, app)
+		lg.Info("category predicted",
+			"name", dInfo.BTName,
+			"infohash", dInfo.MagnetHash,
+			"category", out.Type,
+			"confidence", int(out.Confidence*100),
+			"accepted", err == nil && decision.accept,
+			"reason", decision.reason,
+		)
+		if err != nil || decision.accept == false {
+			// Metadata task is still polled, so mark it not to ask again.
+			app.tasks.update(statusUpd.OwnerID, statusUpd.GID, func(t *downloadTaskInfo) bool {
+				t.TaskStage = stageAwaitingCategory
+				return true
+			})
 			tgClt.GetOutChan() <- tg.NewTextWithKeyboard(
 				statusUpd.OwnerID,
-				fmt.Sprintf("I'm not sure about category of '%s'. Could you please select it yourself?", dInfo.BTName),
-				[]tg.InlineButton{
-					{
-						Text:         "series",
-						CallbackData: fmt.Sprintf("-t=series -gid=%s", statusUpd.GID),
-					},
-					{
-						Text:         "movies",
-						CallbackData: fmt.Sprintf("-t=movies -gid=%s", statusUpd.GID),
-					},
-				},
+				uncertainCategoryText(dInfo.BTName, out),
+				categoryButtons(statusUpd.GID, out),
 			)
 			return
 		}
 		dInfo.DLType = stringToDlType(out.Type)
+		app.metrics.CategoryChosen(metrics.CategoryFromClassifier)
 		tgClt.GetOutChan() <- tg.NewTextMessage(
 			statusUpd.OwnerID,
 			fmt.Sprintf("Download category of '%s' is '%s', I'm %d%% sure ",
@@ -180,21 +271,82 @@ func handleMagnetCompletion(dInfo *downloadTaskInfo, statusUpd *ariactr.TaskStat
 				int(out.Confidence*100)),
 		)
 	}
-	startBTDownload(dInfo, statusUpd.OwnerID, statusUpd.GID, app)
+	startBTDownload(ctx, dInfo, statusUpd.OwnerID, statusUpd.GID, app)
+}
+
+// uncertainCategoryText composes the message asking user to select category
+// listing classificator guesses with their probabilities if there are any.
+func uncertainCategoryText(name string, pred classr.TypePrediction) string {
+	text := fmt.Sprintf("I'm not sure about category of '%s'. Could you please select it yourself?", name)
+	if len(pred.TopK) == 0 {
+		return text
+	}
+	guesses := []string{}
+	for _, lp := range pred.TopK {
+		guesses = append(guesses, fmt.Sprintf("%s %d%%", lp.Type, int(lp.Probability*100)))
+	}
+	text = fmt.Sprintf("%s\nMy guesses are: %s.", text, strings.Join(guesses, ", "))
+	if pred.Explanation != "" {
+		text = fmt.Sprintf("%s\n%s", text, pred.Explanation)
+	}
+	return text
+}
+
+// categoryButtons returns category selection buttons ordered by predicted probability.
+// Categories not mentioned in prediction go last in default order.
+func categoryButtons(gid string, pred classr.TypePrediction) []tg.InlineButton {
+	buttons := []tg.InlineButton{}
+	seen := map[downloadType]bool{}
+	for _, lp := range pred.TopK {
+		t := stringToDlType(lp.Type)
+		if t == unknown || seen[t] {
+			continue
+		}
+		seen[t] = true
+		buttons = append(buttons, tg.InlineButton{
+			Text:         fmt.Sprintf("%s %d%%", t.String(), int(lp.Probability*100)),
+			CallbackData: fmt.Sprintf("-t=%s -gid=%s", t.String(), gid),
+		})
+	}
+	for _, t := range []downloadType{series, movies} {
+		if seen[t] {
+			continue
+		}
+		buttons = append(buttons, tg.InlineButton{
+			Text:         t.String(),
+			CallbackData: fmt.Sprintf("-t=%s -gid=%s", t.String(), gid),
+		})
+	}
+	return buttons
 }
 
-func startBTDownload(dInfo *downloadTaskInfo, owner, gid string, app *application) {
+func startBTDownload(ctx context.Context, dInfo *downloadTaskInfo, owner, gid string, app *application) {
 	ariaClt := app.ariaClient
 	tgClt := app.tgClient
-	db := app.db
-	dlDirs := app.dirs
+	dlDirs := app.settings().dirs
 	torrentFilename := strings.ToLower(dInfo.MagnetHash) + ".torrent"
 
-	err := deleteTaskInfo(owner, gid, db)
 	fullPath := fullDlPath(dInfo.DLType, dInfo.DLDir, dlDirs)
-	newGid, err := ariaClt.EnqueueBT(owner, fullPath, torrentFilename)
+	var size int64
+	if meta, err := torfile.ReadFile(torrentFilename); err == nil {
+		size = meta.TotalLength
+	}
+	if err := checkRoom(fullPath, size, &app.settings().loaded.DiskGuard); err != nil {
+		if errors.Is(err, errNoRoom) {
+			queueForSpace(dInfo, owner, gid, err, app)
+			return
+		}
+		// Downloads aren't held up on the platforms free space couldn't be checked on.
+		app.log.Warn("failed to check free space", "dir", fullPath, "error", err)
+	}
+	var newGid string
+	opts, err := downloadOptions(dInfo, owner, app)
+	if err == nil {
+		newGid, err = ariaClt.EnqueueBT(ctx, owner, fullPath, torrentFilename, opts)
+	}
 	if err != nil {
-		app.errHandler.LogError(err)
+		app.tasks.delete(owner, gid)
+		app.log.Error("failed to start download", "owner", owner, "gid", gid, "error", err)
 		tgClt.GetOutChan() <- tg.NewTextMessage(
 			owner,
 			err.Error(),
@@ -202,67 +354,132 @@ func startBTDownload(dInfo *downloadTaskInfo, owner, gid string, app *applicatio
 		return
 	}
 	dInfo.TaskStage = stageBTDownload
-	err = saveNewTask(owner, newGid, dInfo, db)
+	dInfo.Started = time.Now()
+	err = app.tasks.replace(owner, gid, newGid, dInfo)
 	if err != nil {
-		app.errHandler.LogError(err)
+		app.log.Error("failed to save task", "owner", owner, "gid", newGid, "error", err)
 		tgClt.GetOutChan() <- tg.NewTextMessage(
 			owner,
 			err.Error(),
 		)
 		return
 	}
-	tgClt.GetOutChan() <- tg.NewTextMessage(
-		owner,
-		fmt.Sprintf("Download of '%s' to '%s' category started.", dInfo.BTName, dInfo.DLType.String()),
-	)
+	notifyTask(owner, dInfo, fmt.Sprintf("Download of '%s' to '%s' category started.",
+		dInfo.BTName,
+		dInfo.DLType.String(),
+	), app)
 }
 
-func handleCallback(msg *tg.ChatMessage, app *application) {
+func handleCallback(ctx context.Context, msg *tg.ChatMessage, app *application) {
 	cbTask := ParseCallbackQuery(msg.Text)
 	app.tgClient.GetOutChan() <- tg.NewQueryAnswer(
 		cbTask.CallbackID,
 	)
-	infos, err := getTaskInfosByUser(msg.ChatID, app.db)
-	if err != nil {
+	if cbTask.Dup != "" {
+		handleDuplicateChoice(ctx, msg.ChatID, cbTask, app)
+		return
+	}
+	err := chooseCategory(ctx, msg.ChatID, cbTask.GID, stringToDlType(cbTask.DlType), app)
+	// Buttons of the tasks already started are pressed again sometimes, they are just ignored.
+	if err != nil && errors.Is(err, errNoTask) == false {
 		app.tgClient.GetOutChan() <- tg.NewTextMessage(
 			msg.ChatID,
 			err.Error(),
 		)
-		return
 	}
-	dInfo := infos[cbTask.GID]
-	dInfo.DLType = stringToDlType(cbTask.DlType)
-	startBTDownload(&dInfo, msg.ChatID, cbTask.GID, app)
 }
 
-func handleKillTask(chatID, gid string, app *application) {
-	tasks, err := getTaskInfosByUser(chatID, app.db)
+// chooseCategory starts the download of the task waiting for user to select its category.
+// Returns errNoTask if the owner has no such task waiting for category.
+func chooseCategory(ctx context.Context, owner, gid string, dlType downloadType, app *application) error {
+	dInfo, ok, err := app.tasks.get(owner, gid)
 	if err != nil {
+		return err
+	}
+	if ok == false || (dInfo.TaskStage != stageMagnetMeta && dInfo.TaskStage != stageAwaitingCategory) {
+		return fmt.Errorf("%w waiting for category with ID %s", errNoTask, gid)
+	}
+	dInfo.DLType = dlType
+	app.metrics.CategoryChosen(metrics.CategoryFromUser)
+	startBTDownload(ctx, &dInfo, owner, gid, app)
+	return nil
+}
+
+func handleKillTask(ctx context.Context, chatID, gid string, app *application) {
+	if err := killTask(ctx, chatID, gid, app); err != nil {
 		app.tgClient.GetOutChan() <- tg.NewTextMessage(
 			chatID,
 			err.Error(),
 		)
-		return
 	}
-	if _, ok := tasks[gid]; ok == false {
-		app.tgClient.GetOutChan() <- tg.NewTextMessage(
-			chatID,
-			fmt.Sprintf("You have no tasks with ID %s.", gid),
-		)
-		return
+}
+
+func handlePauseTask(ctx context.Context, chatID, pauseGID, resumeGID string, app *application) {
+	gid, pause := pauseGID, true
+	if gid == "" {
+		gid, pause = resumeGID, false
+	}
+	message := fmt.Sprintf("Task with GID %s paused.", gid)
+	if pause == false {
+		message = fmt.Sprintf("Task with GID %s resumed.", gid)
 	}
-	err = app.ariaClient.KillTask(gid)
+	if err := pauseTask(ctx, chatID, gid, pause, app); err != nil {
+		message = err.Error()
+	}
+	app.tgClient.GetOutChan() <- tg.NewTextMessage(
+		chatID,
+		message,
+	)
+}
+
+// errNoTask is returned when the owner has no task with the GID.
+var errNoTask = errors.New("no such task")
+
+// killTask removes the task of the owner from aria2.
+// The task is deleted from storage once poller reports it removed.
+func killTask(ctx context.Context, owner, gid string, app *application) error {
+	dInfo, err := ownedTask(owner, gid, app)
 	if err != nil {
+		return err
+	}
+	// Metadata of the download waiting for category or space is already complete, there is nothing to stop in aria2.
+	if dInfo.TaskStage == stageAwaitingCategory || dInfo.TaskStage == stageAwaitingSpace {
+		if err = app.tasks.delete(owner, gid); err != nil {
+			return err
+		}
 		app.tgClient.GetOutChan() <- tg.NewTextMessage(
-			chatID,
-			err.Error(),
+			owner,
+			fmt.Sprintf("Task with GID %s removed.", gid),
 		)
-		return
+		return nil
+	}
+	return app.ariaClient.KillTask(ctx, gid)
+}
+
+// pauseTask pauses or resumes the task of the owner.
+func pauseTask(ctx context.Context, owner, gid string, pause bool, app *application) error {
+	if _, err := ownedTask(owner, gid, app); err != nil {
+		return err
+	}
+	if pause {
+		return app.ariaClient.PauseTask(ctx, gid)
 	}
+	return app.ariaClient.UnpauseTask(ctx, gid)
 }
 
-func handleTellActive(chatID string, app *application) {
-	statuses, err := app.ariaClient.TellActive()
+func ownedTask(owner, gid string, app *application) (downloadTaskInfo, error) {
+	dInfo, ok, err := app.tasks.get(owner, gid)
+	if err != nil {
+		return dInfo, err
+	}
+	if ok == false {
+		return dInfo, fmt.Errorf("%w: you have no tasks with ID %s", errNoTask, gid)
+	}
+	return dInfo, nil
+}
+
+func handleTellActive(ctx context.Context, chatID string, app *application) {
+	statuses, err := app.ariaClient.TellActive(ctx)
 	if err != nil {
 		app.tgClient.GetOutChan() <- tg.NewTextMessage(
 			chatID,
@@ -297,18 +514,19 @@ func handleTellActive(chatID string, app *application) {
 	)
 }
 
+// pollSavedTasks resumes polling of the stored tasks aria2 is working on.
 func pollSavedTasks(app *application) error {
-	data, err := app.db.GetAll()
+	tasks, err := app.tasks.all()
 	if err != nil {
 		return err
 	}
-	for userID, v := range data {
-		var dlTaskInfos map[string]downloadTaskInfo
-		err = json.Unmarshal(v, &dlTaskInfos)
-		if err != nil {
-			return err
-		}
-		for gid := range dlTaskInfos {
+	for userID, dlTaskInfos := range tasks {
+		for gid, t := range dlTaskInfos {
+			// Metadata of the tasks waiting for category or space is complete,
+			// aria2 restarted since then doesn't know their GIDs and they would be reported failed.
+			if t.TaskStage == stageAwaitingCategory || t.TaskStage == stageAwaitingSpace {
+				continue
+			}
 			app.ariaClient.AddPollingTask(userID, gid)
 		}
 	}
@@ -317,56 +535,15 @@ func pollSavedTasks(app *application) error {
 
 func prepareMagnetInfo(task *botTask) downloadTaskInfo {
 	return downloadTaskInfo{
-		stageMagnetMeta,
-		hashFromMagnetLink(task.Magnet),
-		task.DlSubdir,
-		stringToDlType(task.DlType),
-		nameFromMagnetLink(task.Magnet),
-	}
-}
-
-func saveNewTask(chatID, gid string, task *downloadTaskInfo, db storage.DBInstancer) error {
-	dlTaskInfos, err := getTaskInfosByUser(chatID, db)
-	if err != nil {
-		return err
-	}
-	dlTaskInfos[gid] = *task
-	newVal, err := json.Marshal(dlTaskInfos)
-	if err != nil {
-		return err
-	}
-	return db.Set([]byte(chatID), newVal)
-}
-
-func getTaskInfosByUser(chatID string, db storage.DBInstancer) (map[string]downloadTaskInfo, error) {
-	v, err := db.Get([]byte(chatID))
-	if err != nil {
-		return nil, err
-	}
-	var dlTaskInfos map[string]downloadTaskInfo
-	err = json.Unmarshal(v, &dlTaskInfos)
-	if err != nil {
-		dlTaskInfos = map[string]downloadTaskInfo{}
-	}
-
-	return dlTaskInfos, nil
-}
-
-func deleteTaskInfo(chatID, gid string, db storage.DBInstancer) error {
-	dlTaskInfos, err := getTaskInfosByUser(chatID, db)
-	if err != nil {
-		return err
-	}
-	delete(dlTaskInfos, gid)
-	v, err := json.Marshal(dlTaskInfos)
-	if err != nil {
-		return err
+		TaskStage:  stageMagnetMeta,
+		MagnetHash: hashFromMagnetLink(task.Magnet),
+		DLDir:      task.DlSubdir,
+		DLType:     stringToDlType(task.DlType),
+		BTName:     nameFromMagnetLink(task.Magnet),
 	}
-	err = db.Set([]byte(chatID), v)
-	return err
 }
 
-func dlCategoryByTorrent(c *classr.Client, file string) (classr.TypePrediction, error) {
+func dlCategoryByTorrent(ctx context.Context, c *classr.Client, infohash, file string) (classr.TypePrediction, error) {
 	var prediction classr.TypePrediction
 
 	wd, err := os.Getwd()
@@ -385,7 +562,7 @@ func dlCategoryByTorrent(c *classr.Client, file string) (classr.TypePrediction,
 	// cleanOut := re.Find(out)
 	// err = json.Unmarshal(cleanOut, &prediction)
 	path := fmt.Sprintf("%s/%s", wd, file)
-	prediction, err = c.PredictClass(path)
+	prediction, err = c.PredictClass(ctx, infohash, path)
 
 	return prediction, err
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"n2bot/ariactr"
+	"n2bot/storage"
+	"n2bot/tg"
+	"testing"
+	"time"
+)
+
+func TestKillAwaitingTask(t *testing.T) {
+	aria := fakeAria2(t)
+	defer aria.Close()
+	ac, err := ariactr.NewClient(&ariactr.Config{Aria2RPCURL: aria.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{tgClient: tg.NewClient(&tg.Config{}), ariaClient: ac, tasks: &taskStore{db: storage.NewMemory()}}
+	for _, stage := range []taskStage{stageAwaitingCategory, stageAwaitingSpace} {
+		if err = app.tasks.save("1", "gid1", &downloadTaskInfo{TaskStage: stage, BTName: "film"}); err != nil {
+			t.Fatal(err)
+		}
+		// Nothing reads Telegram messages here, so the confirmation blocks killTask after the task is deleted.
+		errc := make(chan error, 1)
+		go func() { errc <- killTask(context.Background(), "1", "gid1", app) }()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, ok, _ := app.tasks.get("1", "gid1"); ok == false {
+				break
+			}
+			select {
+			case err = <-errc:
+				t.Fatalf("%s: killTask = %v, task is left to aria2 which has no such GID", stage, err)
+			case <-time.After(10 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: task isn't deleted", stage)
+			}
+		}
+	}
+}
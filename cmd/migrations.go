@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"n2bot/storage"
+	"strconv"
+)
+
+// schemaVersionKey keeps the number of the last applied migration.
+const schemaVersionKey = "meta/schema_version"
+
+// migration upgrades stored records from version-1 to version.
+// Migrations are applied in order on startup and must be idempotent
+// as the version is saved only after the migration succeeds.
+type migration struct {
+	version     int
+	description string
+	apply       func(db storage.DBInstancer) error
+}
+
+var migrations = []migration{
+	{
+		1,
+		"per task keys instead of per user JSON blobs",
+		func(db storage.DBInstancer) error {
+			n, err := migrateBlobTasks(db)
+			if n > 0 {
+				log.Printf("%d tasks moved to per task keys", n)
+			}
+			return err
+		},
+	},
+	{
+		2,
+		"string encoded task stage and download type",
+		reencodeTasks,
+	},
+	{
+		3,
+		"proxy credentials removed from stored tasks",
+		redactTaskProxies,
+	},
+}
+
+// schemaVersion returns the version of stored records, 0 for databases created before versioning.
+func schemaVersion(db storage.DBInstancer) (int, error) {
+	v, err := db.Get([]byte(schemaVersionKey))
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(v))
+}
+
+// runMigrations brings the storage to the latest schema version.
+// It refuses to work with the storage written by the newer version of the bot.
+func runMigrations(db storage.DBInstancer) error {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("storage schema version %d is newer than supported %d", current, latest)
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("migrating storage to schema version %d: %s", m.version, m.description)
+		if err = m.apply(db); err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %w", m.version, err)
+		}
+		if err = db.Set([]byte(schemaVersionKey), []byte(strconv.Itoa(m.version))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactTaskProxies removes credentials from --proxy URLs stored with tasks.
+// Downloads not started yet take credentials of the same proxy from config then.
+func redactTaskProxies(db storage.DBInstancer) error {
+	data, err := db.GetByPrefix([]byte(taskKeyPrefix))
+	if err != nil {
+		return err
+	}
+	b := &storage.Batch{}
+	for k, v := range data {
+		var task downloadTaskInfo
+		if err = json.Unmarshal(v, &task); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		proxy := redactProxy(task.Proxy)
+		if proxy == task.Proxy {
+			continue
+		}
+		task.Proxy = proxy
+		nv, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		b.Set([]byte(k), nv)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return db.Write(b)
+}
+
+// reencodeTasks rewrites all the stored tasks, so enums stored as bare numbers become strings.
+func reencodeTasks(db storage.DBInstancer) error {
+	data, err := db.GetByPrefix([]byte(taskKeyPrefix))
+	if err != nil {
+		return err
+	}
+	b := &storage.Batch{}
+	for k, v := range data {
+		var task downloadTaskInfo
+		if err = json.Unmarshal(v, &task); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		nv, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		b.Set([]byte(k), nv)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return db.Write(b)
+}
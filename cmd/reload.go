@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"n2bot/tg"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// reloadableFields are the config fields applied without restart.
+var reloadableFields = map[string]bool{
+	"ConfThold":       true,
+	"CatTholds":       true,
+	"AcceptRules":     true,
+	"Users":           true,
+	"Admins":          true,
+	"Dirs":            true,
+	"DownloadProxies": true,
+	"DiskGuard":       true,
+}
+
+// reloadConfig reads config file again, checks it and swaps the settings which could be changed at runtime.
+// Returns the names of applied config sections and the ones which need restart to apply.
+// Sections needing restart are kept as they were, so they are reported on every reload until restart.
+func reloadConfig(app *application) (applied, needRestart []string, err error) {
+	var cfg config
+	if _, err = toml.DecodeFile(app.configPath, &cfg); err != nil {
+		return nil, nil, err
+	}
+	applyEnvOverrides(&cfg)
+	if err = validateConfig(&cfg); err != nil {
+		return nil, nil, err
+	}
+	old := app.settings().loaded
+	oldVal := reflect.ValueOf(&old).Elem()
+	newVal := reflect.ValueOf(&cfg).Elem()
+	for i := 0; i < newVal.NumField(); i++ {
+		f := newVal.Type().Field(i)
+		if reflect.DeepEqual(oldVal.Field(i).Interface(), newVal.Field(i).Interface()) {
+			continue
+		}
+		if reloadableFields[f.Name] {
+			applied = append(applied, configKey(f))
+			continue
+		}
+		needRestart = append(needRestart, configKey(f))
+		newVal.Field(i).Set(oldVal.Field(i))
+	}
+	app.current.Store(newSettings(cfg))
+	return applied, needRestart, nil
+}
+
+// configKey returns the name of field as it is written in config file.
+func configKey(f reflect.StructField) string {
+	if tag := f.Tag.Get("toml"); tag != "" {
+		return strings.Split(tag, ",")[0]
+	}
+	return strings.ToLower(f.Name[:1]) + f.Name[1:]
+}
+
+// handleReload reloads config on admin request or on SIGHUP when chatID is empty.
+// The result is sent to the admin or to all admins on SIGHUP.
+func handleReload(chatID string, app *application) {
+	chats := []string{chatID}
+	if chatID == "" {
+		chats = app.settings().admins
+	} else if isAdmin(chatID, app) == false {
+		app.tgClient.GetOutChan() <- tg.NewTextMessage(
+			chatID,
+			"Only admins could reload config.",
+		)
+		return
+	}
+	applied, needRestart, err := reloadConfig(app)
+	var message string
+	switch {
+	case err != nil:
+		app.log.Error("config reload failed", "error", err)
+		message = fmt.Sprintf("Config isn't reloaded: %s", err)
+	case len(applied) == 0 && len(needRestart) == 0:
+		app.log.Info("config reloaded, nothing changed")
+		message = "Config reloaded, nothing changed."
+	default:
+		app.log.Info("config reloaded", "applied", applied, "needRestart", needRestart)
+		message = "Config reloaded."
+		if len(applied) > 0 {
+			message += fmt.Sprintf("\nApplied: %s.", strings.Join(applied, ", "))
+		}
+		if len(needRestart) > 0 {
+			message += fmt.Sprintf("\nNeeds restart: %s.", strings.Join(needRestart, ", "))
+		}
+	}
+	sendToChats(app, chats, message)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+const reloadTestConfig = `
+confThold = 60
+users = ["1"]
+admins = ["1"]
+[downloadDirectories]
+movies = "%[1]s"
+series = "%[1]s"
+general = "%[1]s"
+[tgClient]
+token = "old"
+[storageConfig]
+backendType = "memory"
+`
+
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(reloadTestConfig, dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var cfg config
+	if _, err := toml.DecodeFile(path, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	app := &application{configPath: path}
+	app.current.Store(newSettings(cfg))
+
+	changed := `
+confThold = 70
+users = ["1", "2"]
+admins = ["1"]
+[downloadDirectories]
+movies = "%[1]s"
+series = "%[1]s"
+general = "%[1]s"
+[tgClient]
+token = "new"
+[storageConfig]
+backendType = "memory"
+`
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(changed, dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	applied, needRestart, err := reloadConfig(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(applied, []string{"confThold", "users"}) == false {
+		t.Errorf("unexpected applied sections %v", applied)
+	}
+	if reflect.DeepEqual(needRestart, []string{"tgClient"}) == false {
+		t.Errorf("unexpected sections needing restart %v", needRestart)
+	}
+	s := app.settings()
+	if s.confThold != 70 || len(s.users) != 2 {
+		t.Errorf("settings aren't applied: %+v", s)
+	}
+	if s.loaded.TgClientConfig.Token != "old" {
+		t.Error("section needing restart is changed in loaded config")
+	}
+
+	if err = os.WriteFile(path, []byte(`users = []`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = reloadConfig(app); err == nil {
+		t.Error("invalid config is accepted")
+	}
+	if app.settings() != s {
+		t.Error("settings are swapped after failed reload")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"n2bot/storage"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runSubcommand runs maintenance subcommands working with the storage only.
+// The bot must be stopped to import, as import rewrites the storage.
+// Export reads consistent snapshot, but goleveldb and bbolt lock the database
+// files, so use /backup command or scheduled backups while the bot is running.
+func runSubcommand(args []string, cfg *config) error {
+	switch args[0] {
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		out := fs.String("o", "", "file to export to, stdout when empty")
+		fs.Parse(args[1:])
+		return withStorage(cfg, func(db storage.DBInstancer) error {
+			var w io.Writer = os.Stdout
+			if *out != "" {
+				f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			n, err := storage.Export(db, w)
+			if err == nil {
+				log.Printf("%d records exported", n)
+			}
+			return err
+		})
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		in := fs.String("i", "", "file to import from, stdin when empty")
+		overwrite := fs.Bool("overwrite", false, "delete everything in the storage before import")
+		fs.Parse(args[1:])
+		return withStorage(cfg, func(db storage.DBInstancer) error {
+			var r io.Reader = os.Stdin
+			if *in != "" {
+				f, err := os.Open(*in)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			n, err := storage.Import(db, r, *overwrite, validateExportRecord)
+			if err != nil {
+				return err
+			}
+			log.Printf("%d records imported", n)
+			// Export could be made by the older version of the bot.
+			return runMigrations(db)
+		})
+	case "backup":
+		fs := flag.NewFlagSet("backup", flag.ExitOnError)
+		dir := fs.String("dir", cfg.BackupConfig.Dir, "directory to write backup to")
+		fs.Parse(args[1:])
+		if *dir == "" {
+			return errors.New("backup directory is not set")
+		}
+		return withStorage(cfg, func(db storage.DBInstancer) error {
+			path, err := storage.Backup(db, *dir, int(cfg.BackupConfig.Keep))
+			if err == nil {
+				log.Printf("backup written to %s", path)
+			}
+			return err
+		})
+	}
+	return fmt.Errorf("unknown subcommand %q, expected export, import or backup", args[0])
+}
+
+func withStorage(cfg *config, fn func(db storage.DBInstancer) error) error {
+	db, err := storage.NewInstance(&cfg.StorageConfig)
+	if err != nil {
+		return err
+	}
+	err = fn(db)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// validateExportRecord checks that the records the bot relies on are decodable.
+func validateExportRecord(key string, value []byte) error {
+	switch {
+	case key == schemaVersionKey:
+		_, err := strconv.Atoi(string(value))
+		return err
+	case strings.HasPrefix(key, taskKeyPrefix):
+		var task downloadTaskInfo
+		return json.Unmarshal(value, &task)
+	case strings.HasPrefix(key, storage.HistoryKeyPrefix):
+		var rec storage.HistoryRecord
+		return json.Unmarshal(value, &rec)
+	case strings.HasPrefix(key, gidKeyPrefix), strings.HasPrefix(key, hashKeyPrefix):
+		if len(value) == 0 {
+			return errors.New("index has no owner")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"n2bot/ariactr"
+	"n2bot/supervisor"
+	"n2bot/tg"
+)
+
+// watchComponents logs components state changes
+// and notifies users when aria2 goes away and comes back.
+func watchComponents(sup *supervisor.Supervisor, app *application) {
+	// Events of one component come from its own goroutine, so ariaDown isn't shared.
+	ariaDown := false
+	sup.OnStateChange(func(e supervisor.Event) {
+		if e.State == supervisor.Down {
+			app.log.Warn("component is down", "name", e.Component, "error", e.Err)
+		} else {
+			app.log.Info("component state changed", "name", e.Component, "state", e.State.String())
+		}
+		if e.Component != ariactr.PollerComponent {
+			return
+		}
+		if e.State == supervisor.Down && ariaDown == false {
+			ariaDown = true
+			notifyUsers(app, "⚠️ aria2 went away, downloads status isn't tracked until it is back.")
+		}
+		if e.Recovered && ariaDown {
+			ariaDown = false
+			notifyUsers(app, "✅ aria2 is back, tracking downloads again.")
+		}
+	})
+}
+
+// notifyUsers sends the text to every user.
+func notifyUsers(app *application, text string) {
+	sendToChats(app, app.settings().users, text)
+}
+
+// sendToChats sends the text without blocking the caller,
+// Telegram sender could be restarting itself.
+func sendToChats(app *application, chats []string, text string) {
+	for _, chat := range chats {
+		if chat == "" {
+			continue
+		}
+		go func(chat string) {
+			app.tgClient.GetOutChan() <- tg.NewTextMessage(chat, text)
+		}(chat)
+	}
+}
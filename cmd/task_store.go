@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"n2bot/metrics"
+	"n2bot/storage"
+	"strings"
+	"sync"
+)
+
+// taskStore keeps every download task under its own key
+// with secondary indexes by GID and by infohash:
+//
+//	task/<owner>/<gid>     JSON of downloadTaskInfo
+//	gid/<gid>              owner
+//	hash/<infohash>/<gid>  owner
+//
+// Read-modify-write sequences are serialized with the mutex
+// and every change of the task with its indexes is written as one batch.
+type taskStore struct {
+	mu sync.Mutex
+	db storage.DBInstancer
+}
+
+// taskRef points to the stored task.
+type taskRef struct {
+	Owner string
+	GID   string
+}
+
+const (
+	taskKeyPrefix = "task/"
+	gidKeyPrefix  = "gid/"
+	hashKeyPrefix = "hash/"
+)
+
+func taskKey(owner, gid string) []byte {
+	return []byte(taskKeyPrefix + owner + "/" + gid)
+}
+
+func gidKey(gid string) []byte {
+	return []byte(gidKeyPrefix + gid)
+}
+
+func hashKey(infohash, gid string) []byte {
+	return []byte(hashKeyPrefix + strings.ToLower(infohash) + "/" + gid)
+}
+
+// save creates or overwrites the task.
+func (s *taskStore) save(owner, gid string, task *downloadTaskInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &storage.Batch{}
+	if err := s.putToBatch(b, owner, gid, task); err != nil {
+		return err
+	}
+	return s.db.Write(b)
+}
+
+// get returns the task and false if there is no such task of the owner.
+func (s *taskStore) get(owner, gid string) (downloadTaskInfo, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getLocked(owner, gid)
+}
+
+// update calls fn with the stored task and saves the result if fn returns true.
+// Returns false if there is no such task, so removed tasks are never resurrected.
+func (s *taskStore) update(owner, gid string, fn func(task *downloadTaskInfo) bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok, err := s.getLocked(owner, gid)
+	if err != nil || ok == false {
+		return false, err
+	}
+	if fn(&task) == false {
+		return true, nil
+	}
+	b := &storage.Batch{}
+	if err := s.putToBatch(b, owner, gid, &task); err != nil {
+		return true, err
+	}
+	return true, s.db.Write(b)
+}
+
+// replace deletes the task under oldGid and saves the task under newGid in one batch.
+func (s *taskStore) replace(owner, oldGid, newGid string, task *downloadTaskInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &storage.Batch{}
+	if err := s.deleteToBatch(b, owner, oldGid); err != nil {
+		return err
+	}
+	if err := s.putToBatch(b, owner, newGid, task); err != nil {
+		return err
+	}
+	return s.db.Write(b)
+}
+
+// delete removes the task with its indexes.
+func (s *taskStore) delete(owner, gid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &storage.Batch{}
+	if err := s.deleteToBatch(b, owner, gid); err != nil {
+		return err
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return s.db.Write(b)
+}
+
+// byUser returns all the tasks of the owner keyed by GID.
+func (s *taskStore) byUser(owner string) (map[string]downloadTaskInfo, error) {
+	data, err := s.db.GetByPrefix([]byte(taskKeyPrefix + owner + "/"))
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]downloadTaskInfo{}
+	for k, v := range data {
+		var task downloadTaskInfo
+		if err = json.Unmarshal(v, &task); err != nil {
+			return nil, err
+		}
+		result[k[strings.LastIndex(k, "/")+1:]] = task
+	}
+	return result, nil
+}
+
+// all returns all the stored tasks keyed by owner then by GID.
+func (s *taskStore) all() (map[string]map[string]downloadTaskInfo, error) {
+	data, err := s.db.GetByPrefix([]byte(taskKeyPrefix))
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]map[string]downloadTaskInfo{}
+	for k, v := range data {
+		parts := strings.SplitN(strings.TrimPrefix(k, taskKeyPrefix), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var task downloadTaskInfo
+		if err = json.Unmarshal(v, &task); err != nil {
+			return nil, err
+		}
+		if result[parts[0]] == nil {
+			result[parts[0]] = map[string]downloadTaskInfo{}
+		}
+		result[parts[0]][parts[1]] = task
+	}
+	return result, nil
+}
+
+// countByStage counts all the stored tasks by stage and category for metrics.
+func (s *taskStore) countByStage() ([]metrics.TaskCount, error) {
+	all, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+	type key struct{ stage, category string }
+	counts := map[key]int{}
+	for _, tasks := range all {
+		for _, t := range tasks {
+			counts[key{t.TaskStage.String(), t.DLType.String()}]++
+		}
+	}
+	result := []metrics.TaskCount{}
+	for k, n := range counts {
+		result = append(result, metrics.TaskCount{Stage: k.stage, Category: k.category, Count: n})
+	}
+	return result, nil
+}
+
+// ownerOf returns the owner of the task by GID or empty string if there is no such task.
+func (s *taskStore) ownerOf(gid string) (string, error) {
+	v, err := s.db.Get(gidKey(gid))
+	return string(v), err
+}
+
+// byInfohash returns references to all the tasks downloading the torrent.
+func (s *taskStore) byInfohash(infohash string) ([]taskRef, error) {
+	prefix := hashKeyPrefix + strings.ToLower(infohash) + "/"
+	data, err := s.db.GetByPrefix([]byte(prefix))
+	if err != nil {
+		return nil, err
+	}
+	refs := []taskRef{}
+	for k, v := range data {
+		refs = append(refs, taskRef{string(v), strings.TrimPrefix(k, prefix)})
+	}
+	return refs, nil
+}
+
+func (s *taskStore) getLocked(owner, gid string) (downloadTaskInfo, bool, error) {
+	var task downloadTaskInfo
+	v, err := s.db.Get(taskKey(owner, gid))
+	if err != nil || v == nil {
+		return task, false, err
+	}
+	err = json.Unmarshal(v, &task)
+	return task, err == nil, err
+}
+
+func (s *taskStore) putToBatch(b *storage.Batch, owner, gid string, task *downloadTaskInfo) error {
+	v, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	b.Set(taskKey(owner, gid), v)
+	b.Set(gidKey(gid), []byte(owner))
+	if task.MagnetHash != "" {
+		b.Set(hashKey(task.MagnetHash, gid), []byte(owner))
+	}
+	return nil
+}
+
+func (s *taskStore) deleteToBatch(b *storage.Batch, owner, gid string) error {
+	task, ok, err := s.getLocked(owner, gid)
+	if err != nil {
+		return err
+	}
+	if ok == false {
+		return nil
+	}
+	b.Delete(taskKey(owner, gid))
+	b.Delete(gidKey(gid))
+	if task.MagnetHash != "" {
+		b.Delete(hashKey(task.MagnetHash, gid))
+	}
+	return nil
+}
+
+// migrateBlobTasks converts tasks stored the old way as one JSON blob
+// of map[gid]downloadTaskInfo under the owner chat ID key to per task keys.
+func migrateBlobTasks(db storage.DBInstancer) (int, error) {
+	data, err := db.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	ts := &taskStore{db: db}
+	b := &storage.Batch{}
+	migrated := 0
+	for k, v := range data {
+		if strings.Contains(k, "/") {
+			continue
+		}
+		var dlTaskInfos map[string]downloadTaskInfo
+		if err = json.Unmarshal(v, &dlTaskInfos); err != nil {
+			continue
+		}
+		for gid, task := range dlTaskInfos {
+			task := task
+			if err = ts.putToBatch(b, k, gid, &task); err != nil {
+				return 0, err
+			}
+			migrated++
+		}
+		b.Delete([]byte(k))
+	}
+	if b.Len() == 0 {
+		return 0, nil
+	}
+	return migrated, db.Write(b)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"n2bot/storage"
+	"testing"
+)
+
+func TestTaskStore(t *testing.T) {
+	ts := &taskStore{db: storage.NewMemory()}
+	task := downloadTaskInfo{TaskStage: stageMagnetMeta, MagnetHash: "ABC", BTName: "name"}
+	if err := ts.save("1", "gid1", &task); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := ts.ownerOf("gid1"); owner != "1" {
+		t.Fatalf("ownerOf = %q; want %q", owner, "1")
+	}
+	if refs, _ := ts.byInfohash("abc"); len(refs) != 1 || refs[0] != (taskRef{"1", "gid1"}) {
+		t.Fatalf("byInfohash = %v; want [{1 gid1}]", refs)
+	}
+
+	task.TaskStage = stageBTDownload
+	if err := ts.replace("1", "gid1", "gid2", &task); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := ts.get("1", "gid1"); ok {
+		t.Fatal("replaced task is still stored")
+	}
+	if owner, _ := ts.ownerOf("gid1"); owner != "" {
+		t.Fatalf("GID index of replaced task is still stored: %q", owner)
+	}
+
+	ok, err := ts.update("1", "gid2", func(t *downloadTaskInfo) bool {
+		t.TaskStage = stageSeeding
+		return true
+	})
+	if err != nil || ok == false {
+		t.Fatalf("update = %t, %v; want true, nil", ok, err)
+	}
+	got, _ := ts.byUser("1")
+	if len(got) != 1 || got["gid2"].TaskStage != stageSeeding {
+		t.Fatalf("byUser = %+v; want gid2 seeding", got)
+	}
+
+	if err = ts.delete("1", "gid2"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ = ts.update("1", "gid2", func(*downloadTaskInfo) bool { return true }); ok {
+		t.Fatal("update resurrected deleted task")
+	}
+	all, _ := ts.db.GetAll()
+	if len(all) != 0 {
+		t.Fatalf("storage is not empty after delete: %q", all)
+	}
+}
+
+func TestMigrateBlobTasks(t *testing.T) {
+	db := storage.NewMemory()
+	blob, _ := json.Marshal(map[string]downloadTaskInfo{
+		"gid1": {MagnetHash: "aa"},
+		"gid2": {MagnetHash: "bb"},
+	})
+	db.Set([]byte("1"), blob)
+	n, err := migrateBlobTasks(db)
+	if err != nil || n != 2 {
+		t.Fatalf("migrateBlobTasks = %d, %v; want 2, nil", n, err)
+	}
+	if v, _ := db.Get([]byte("1")); v != nil {
+		t.Fatal("old blob is not deleted")
+	}
+	ts := &taskStore{db: db}
+	all, _ := ts.all()
+	if len(all["1"]) != 2 {
+		t.Fatalf("all = %+v; want two tasks of user 1", all)
+	}
+}
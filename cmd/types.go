@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"n2bot/ariactr"
 	"n2bot/classr"
-	"n2bot/fatalist"
+	"n2bot/logger"
+	"n2bot/metrics"
 	"n2bot/proxyurl"
 	"n2bot/storage"
+	"n2bot/supervisor"
 	"n2bot/tg"
+	"sync/atomic"
+	"time"
 )
 
 type application struct {
@@ -14,21 +20,82 @@ type application struct {
 	ariaClient   *ariactr.Client
 	classrClient *classr.Client
 	db           storage.DBInstancer
-	dirs         *downloadDirectories
-	errHandler   *fatalist.Fatalist
-	confThold    uint8
-	users        []string
+	tasks        *taskStore
+	log          *logger.Logger
+	backupCfg    *storage.BackupConfig
+	// proxyPool is nil when no proxies are configured.
+	proxyPool   *proxyurl.Pool
+	errReporter *errorReporter
+	// metrics is nil when metrics aren't served.
+	metrics *metrics.Metrics
+	// web is nil when web dashboard isn't enabled.
+	web *webAuth
+	// proxyCreds holds the credentials of proxies set with --proxy flag.
+	proxyCreds proxyCredentials
+	// offers holds the magnets of duplicate torrents users are asked about.
+	offers duplicateOffers
+	// current holds the settings swapped on config reload, read them with settings().
+	current atomic.Pointer[settings]
+	// configPath is the config file reloaded on SIGHUP or /reload command.
+	configPath string
+}
+
+// settings are the parts of config which could be changed at runtime.
+// settings are never modified after creation, reload swaps them as a whole.
+type settings struct {
+	// loaded is the config as it was read from file before any defaults were set.
+	loaded          config
+	dirs            *downloadDirectories
+	confThold       uint8
+	catTholds       map[downloadType]uint8
+	acceptRules     []acceptRule
+	users           []string
+	admins          []string
+	downloadProxies map[downloadType]downloadProxy
+}
+
+func (app *application) settings() *settings {
+	return app.current.Load()
+}
+
+func newSettings(cfg config) *settings {
+	return &settings{
+		loaded:          cfg,
+		dirs:            &cfg.Dirs,
+		confThold:       cfg.ConfThold,
+		catTholds:       catTholdsFromConfig(cfg.CatTholds),
+		acceptRules:     cfg.AcceptRules,
+		users:           cfg.Users,
+		admins:          cfg.Admins,
+		downloadProxies: downloadProxiesFromConfig(cfg.DownloadProxies),
+	}
 }
 
 type config struct {
-	ConfThold      uint8
-	Users          []string
-	Dirs           downloadDirectories `toml:"downloadDirectories"`
-	TgClientConfig tg.Config           `toml:"tgClient"`
-	ProxyConfig    proxyurl.Config
-	AriaConfig     ariactr.Config `toml:"ariaClient"`
-	ClassrConfig   classr.Config  `toml:"classificator"`
-	StorageConfig  storage.Config
+	// ShutdownTimeout is the time in seconds to finish handling messages and flush replies on exit.
+	// ShutdownTimeout defaults to 10 seconds when 0.
+	ShutdownTimeout  uint
+	ConfThold        uint8
+	CatTholds        map[string]uint8 `toml:"confTholds"`
+	AcceptRules      []acceptRule
+	Users            []string
+	Admins           []string
+	Dirs             downloadDirectories `toml:"downloadDirectories"`
+	TgClientConfig   tg.Config           `toml:"tgClient"`
+	ProxyConfig      proxyurl.Config
+	AriaConfig       ariactr.Config `toml:"ariaClient"`
+	ClassrConfig     classr.Config  `toml:"classificator"`
+	StorageConfig    storage.Config
+	BackupConfig     storage.BackupConfig `toml:"backup"`
+	SupervisorConfig supervisor.Config    `toml:"supervisor"`
+	LogConfig        logger.Config        `toml:"log"`
+	ErrorReport      errorReportConfig    `toml:"errorReport"`
+	DiskGuard        diskGuardConfig      `toml:"diskGuard"`
+	API              apiConfig            `toml:"api"`
+	Health           healthConfig         `toml:"health"`
+	Web              webConfig            `toml:"web"`
+	// DownloadProxies are keyed by category or "default".
+	DownloadProxies map[string]downloadProxy `toml:"downloadProxies"`
 }
 
 type downloadTaskInfo struct {
@@ -37,6 +104,17 @@ type downloadTaskInfo struct {
 	DLDir      string
 	DLType     downloadType
 	BTName     string
+	// Proxy is the value of --proxy flag: proxy URL, "pool" or "off".
+	// Proxy credentials are never stored, see proxyCredentials.
+	Proxy string `json:",omitempty"`
+	// Started is the time the download of torrent contents started.
+	Started time.Time
+	// Downloaded is the time the download completed and seeding started.
+	Downloaded time.Time
+	// Queued is the time the download was queued waiting for free space.
+	Queued time.Time
+	// Watchers are the users notified about the task along with its owner.
+	Watchers []string `json:",omitempty"`
 }
 
 type downloadDirectories struct {
@@ -51,8 +129,52 @@ const (
 	stageMagnetMeta taskStage = iota
 	stageBTDownload
 	stageSeeding
+	stageAwaitingCategory
+	stageAwaitingSpace
 )
 
+func (s taskStage) String() string {
+	switch s {
+	case stageMagnetMeta:
+		return "magnet_meta"
+	case stageBTDownload:
+		return "bt_download"
+	case stageSeeding:
+		return "seeding"
+	case stageAwaitingCategory:
+		return "awaiting_category"
+	case stageAwaitingSpace:
+		return "awaiting_space"
+	default:
+		return "error"
+	}
+}
+
+// MarshalJSON stores taskStage as a string so reordering of constants never corrupts stored records.
+func (s taskStage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON accepts both the string and the legacy numeric encoding.
+func (s *taskStage) UnmarshalJSON(b []byte) error {
+	var val string
+	if err := json.Unmarshal(b, &val); err != nil {
+		var num byte
+		if err = json.Unmarshal(b, &num); err != nil {
+			return err
+		}
+		*s = taskStage(num)
+		return nil
+	}
+	for _, st := range []taskStage{stageMagnetMeta, stageBTDownload, stageSeeding, stageAwaitingCategory, stageAwaitingSpace} {
+		if st.String() == val {
+			*s = st
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown task stage %q", val)
+}
+
 type downloadType byte
 
 func (t downloadType) String() string {
@@ -70,19 +192,25 @@ func (t downloadType) String() string {
 	}
 }
 
-// func (t *downloadType) UnmarshalJSON(b []byte) error {
-// 	var val string
-// 	err := json.Unmarshal(b, &val)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	*t = stringToDlType(val)
-// 	return nil
-// }
-
-// func (t *downloadType) MarshalJSON(b []byte) ([]byte, error) {
-// 	return []byte(t.String()), nil
-// }
+// MarshalJSON stores downloadType as a string so reordering of constants never corrupts stored records.
+func (t downloadType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON accepts both the string and the legacy numeric encoding.
+func (t *downloadType) UnmarshalJSON(b []byte) error {
+	var val string
+	if err := json.Unmarshal(b, &val); err != nil {
+		var num byte
+		if err = json.Unmarshal(b, &num); err != nil {
+			return err
+		}
+		*t = downloadType(num)
+		return nil
+	}
+	*t = stringToDlType(val)
+	return nil
+}
 
 const (
 	unknown downloadType = iota
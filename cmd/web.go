@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"n2bot/storage"
+	"n2bot/tg"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webConfig configures the web dashboard served on [api] listen address.
+type webConfig struct {
+	// Enabled serves the dashboard at /web/.
+	Enabled bool
+	// BaseURL is the address browsers reach the bot's HTTP server at, e.g. "https://nas.local:8090".
+	// Links sent by /weblogin start with it.
+	BaseURL string
+	// SessionHours is the time in hours the browser stays logged in.
+	// SessionHours defaults to 168 (a week) when 0.
+	SessionHours uint
+}
+
+const (
+	// webLoginTTL is the time the link sent by /weblogin could be used within.
+	webLoginTTL = 10 * time.Minute
+	// webHistoryLen is the number of the most recent downloads shown on the dashboard.
+	webHistoryLen = 50
+	sessionCookie = "n2bot_session"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// webAuth issues one-time login links and signs session cookies.
+// The signing key is generated on start, so restart logs everyone out.
+type webAuth struct {
+	key        []byte
+	sessionTTL time.Duration
+	// secure limits session cookie to HTTPS when the dashboard is served over it.
+	secure bool
+	mu     sync.Mutex
+	logins map[string]webLogin
+}
+
+type webLogin struct {
+	user    string
+	expires time.Time
+}
+
+func newWebAuth(cfg *webConfig) (*webAuth, error) {
+	if cfg.SessionHours == 0 {
+		cfg.SessionHours = 168
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &webAuth{
+		key:        key,
+		sessionTTL: time.Duration(cfg.SessionHours) * time.Hour,
+		secure:     strings.HasPrefix(strings.ToLower(cfg.BaseURL), "https://"),
+		logins:     map[string]webLogin{},
+	}, nil
+}
+
+// newLoginToken returns the token to log in the user with once within webLoginTTL.
+func (a *webAuth) newLoginToken(user string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for t, l := range a.logins {
+		if now.After(l.expires) {
+			delete(a.logins, t)
+		}
+	}
+	a.logins[token] = webLogin{user, now.Add(webLoginTTL)}
+	return token, nil
+}
+
+// redeem returns the user of the login token, the token couldn't be used again.
+func (a *webAuth) redeem(token string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l, ok := a.logins[token]
+	delete(a.logins, token)
+	if ok == false || time.Now().After(l.expires) {
+		return "", false
+	}
+	return l.user, true
+}
+
+// newSession returns the cookie holding the user and the expiry time signed with the key.
+func (a *webAuth) newSession(user string) *http.Cookie {
+	expires := time.Now().Add(a.sessionTTL)
+	payload := user + "." + strconv.FormatInt(expires.Unix(), 10)
+	return &http.Cookie{
+		Name:     sessionCookie,
+		Value:    payload + "." + a.sign(payload),
+		Path:     "/web/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   a.secure,
+		// Strict same site cookie keeps other sites from posting to the dashboard on user's behalf.
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// sessionUser returns the user of the valid session cookie.
+func (a *webAuth) sessionUser(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	i := strings.LastIndex(c.Value, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := c.Value[:i], c.Value[i+1:]
+	if hmac.Equal([]byte(sig), []byte(a.sign(payload))) == false {
+		return "", false
+	}
+	user, expires, ok := strings.Cut(payload, ".")
+	if ok == false {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return "", false
+	}
+	return user, true
+}
+
+func (a *webAuth) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// handleWebLogin sends the one-time link to log in to the dashboard.
+func handleWebLogin(chatID string, app *application) {
+	cfg := &app.settings().loaded.Web
+	message := "Web dashboard isn't enabled."
+	if app.web != nil {
+		token, err := app.web.newLoginToken(chatID)
+		if err != nil {
+			message = err.Error()
+		} else {
+			message = fmt.Sprintf(
+				"Open %s/web/login?token=%s within %d minutes to log in. The link works once.",
+				strings.TrimSuffix(cfg.BaseURL, "/"),
+				token,
+				int(webLoginTTL.Minutes()),
+			)
+		}
+	}
+	// Link preview would fetch the link before the user opens it.
+	app.tgClient.GetOutChan() <- tg.NewTextWithoutPreview(
+		chatID,
+		message,
+	)
+}
+
+// loginPage asks to confirm logging in, GET never redeems the token
+// as link previews and prefetching open the links users haven't clicked.
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>n2bot</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<main>
+  <form method="post" action="login">
+    <input type="hidden" name="token" value="{{.}}">
+    <button type="submit">Log in to n2bot</button>
+  </form>
+</main>
+</body>
+</html>
+`))
+
+// webRoutes serves the dashboard assets, login and the JSON endpoints the dashboard uses.
+func webRoutes(mux *http.ServeMux, app *application) {
+	assets, _ := fs.Sub(webAssets, "web")
+	mux.Handle("GET /web/", http.StripPrefix("/web/", http.FileServer(http.FS(assets))))
+	mux.HandleFunc("GET /web/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, r.URL.Query().Get("token"))
+	})
+	mux.HandleFunc("POST /web/login", func(w http.ResponseWriter, r *http.Request) {
+		user, ok := app.web.redeem(r.FormValue("token"))
+		if ok == false || isUser(user, app) == false {
+			http.Error(w, "The link is invalid or expired, send /weblogin to the bot to get a new one.", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, app.web.newSession(user))
+		http.Redirect(w, r, "/web/", http.StatusSeeOther)
+	})
+	mux.HandleFunc("POST /web/logout", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/web/", MaxAge: -1})
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /web/api/tasks", withSession(app, webListTasks))
+	mux.HandleFunc("POST /web/api/tasks", withSession(app, webAddTask))
+	mux.HandleFunc("GET /web/api/history", withSession(app, webHistory))
+	mux.HandleFunc("POST /web/api/tasks/{gid}/category", withSession(app, webChooseCategory))
+	mux.HandleFunc("DELETE /web/api/tasks/{gid}", withSession(app, webKillTask))
+	mux.HandleFunc("POST /web/api/tasks/{gid}/pause", withSession(app, webPauseTask))
+	mux.HandleFunc("POST /web/api/tasks/{gid}/resume", withSession(app, webResumeTask))
+}
+
+// withSession passes the logged in user to the handler.
+// Users removed from config lose access right away.
+func withSession(
+	app *application,
+	next func(w http.ResponseWriter, r *http.Request, user string, app *application),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := app.web.sessionUser(r)
+		if ok == false || isUser(user, app) == false {
+			writeAPIError(w, http.StatusUnauthorized, errors.New("not logged in"))
+			return
+		}
+		next(w, r, user, app)
+	}
+}
+
+// webListTasks lists the user's tasks with their aria2 statuses.
+func webListTasks(w http.ResponseWriter, r *http.Request, user string, app *application) {
+	stored, err := app.tasks.byUser(user)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	gids := []string{}
+	for gid := range stored {
+		gids = append(gids, gid)
+	}
+	sort.Strings(gids)
+	statuses, err := app.ariaClient.TellStatuses(r.Context(), gids)
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	byGID := map[string]*apiTaskStatus{}
+	for i := range statuses {
+		byGID[statuses[i].GID] = newAPITaskStatus(&statuses[i])
+	}
+	tasks := []apiTask{}
+	for _, gid := range gids {
+		t := stored[gid]
+		task := newAPITask(user, gid, &t)
+		task.Status = byGID[gid]
+		tasks = append(tasks, task)
+	}
+	writeAPIResult(w, http.StatusOK, tasks)
+}
+
+func webAddTask(w http.ResponseWriter, r *http.Request, user string, app *application) {
+	var req struct {
+		Magnet   string `json:"magnet"`
+		Category string `json:"category"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if strings.HasPrefix(req.Magnet, "magnet:?") == false {
+		writeAPIError(w, http.StatusBadRequest, errors.New("magnet link is expected"))
+		return
+	}
+	if req.Category != "" && stringToDlType(req.Category) == unknown {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("unknown category %q", req.Category))
+		return
+	}
+	gid, err := enqueueUniqueMagnet(r.Context(), user, &botTask{Magnet: req.Magnet, DlType: req.Category}, app)
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	writeAPIResult(w, http.StatusCreated, map[string]string{"gid": gid})
+}
+
+func webChooseCategory(w http.ResponseWriter, r *http.Request, user string, app *application) {
+	var req struct {
+		Category string `json:"category"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	dlType := stringToDlType(req.Category)
+	if dlType == unknown {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("unknown category %q", req.Category))
+		return
+	}
+	writeChangeResult(w, chooseCategory(r.Context(), user, r.PathValue("gid"), dlType, app))
+}
+
+func webHistory(w http.ResponseWriter, r *http.Request, user string, app *application) {
+	records, err := storage.QueryHistory(app.db, &storage.HistoryQuery{Owner: user, Limit: webHistoryLen})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if records == nil {
+		records = []storage.HistoryRecord{}
+	}
+	writeAPIResult(w, http.StatusOK, records)
+}
+
+func webKillTask(w http.ResponseWriter, r *http.Request, user string, app *application) {
+	writeChangeResult(w, killTask(r.Context(), user, r.PathValue("gid"), app))
+}
+
+func webPauseTask(w http.ResponseWriter, r *http.Request, user string, app *application) {
+	writeChangeResult(w, pauseTask(r.Context(), user, r.PathValue("gid"), true, app))
+}
+
+func webResumeTask(w http.ResponseWriter, r *http.Request, user string, app *application) {
+	writeChangeResult(w, pauseTask(r.Context(), user, r.PathValue("gid"), false, app))
+}
+
+func writeChangeResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
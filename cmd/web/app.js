@@ -0,0 +1,175 @@
+"use strict";
+
+// refreshInterval is the time in milliseconds between task list updates.
+const refreshInterval = 5000;
+const categories = ["movies", "series", "common"];
+
+const $ = (id) => document.getElementById(id);
+
+class LoggedOut extends Error {}
+
+async function call(method, path, body) {
+  const opts = {method, headers: {}};
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const resp = await fetch("api/" + path, opts);
+  if (resp.status === 401) {
+    throw new LoggedOut();
+  }
+  if (resp.status === 204) {
+    return null;
+  }
+  const data = await resp.json();
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+function humanBytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return n.toFixed(i === 0 ? 0 : 1) + " " + units[i];
+}
+
+function cell(row, text, cls) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (cls) {
+    td.className = cls;
+  }
+  return td;
+}
+
+function button(parent, label, action) {
+  const b = document.createElement("button");
+  b.type = "button";
+  b.textContent = label;
+  b.onclick = () => act(action);
+  parent.appendChild(b);
+}
+
+function progressCell(row, status) {
+  const td = row.insertCell();
+  if (!status || status.totalLength === 0) {
+    td.textContent = status ? status.status : "";
+    td.className = "dim";
+    return;
+  }
+  const p = document.createElement("progress");
+  p.max = status.totalLength;
+  p.value = status.completedLength;
+  td.appendChild(p);
+  const pct = Math.floor(100 * status.completedLength / status.totalLength);
+  td.appendChild(document.createTextNode(" " + pct + "% of " + humanBytes(status.totalLength)));
+}
+
+function renderTasks(tasks) {
+  const body = $("tasks");
+  body.replaceChildren();
+  for (const t of tasks) {
+    const row = body.insertRow();
+    cell(row, t.name || t.infohash || t.gid, "name");
+    cell(row, t.category);
+    cell(row, t.status && t.status.status === "paused" ? "paused" : t.stage);
+    progressCell(row, t.status);
+    const actions = cell(row, "", "actions");
+    const gid = encodeURIComponent(t.gid);
+    if (t.stage === "awaiting_category") {
+      for (const c of categories) {
+        button(actions, c, () => call("POST", "tasks/" + gid + "/category", {category: c}));
+      }
+    }
+    if (t.status && t.status.status === "paused") {
+      button(actions, "Resume", () => call("POST", "tasks/" + gid + "/resume"));
+    } else if (t.status && t.status.status === "active") {
+      button(actions, "Pause", () => call("POST", "tasks/" + gid + "/pause"));
+    }
+    button(actions, "Remove", () => {
+      if (confirm("Remove " + (t.name || t.gid) + "?")) {
+        return call("DELETE", "tasks/" + gid);
+      }
+    });
+  }
+  if (tasks.length === 0) {
+    cell(body.insertRow(), "No downloads.", "dim").colSpan = 5;
+  }
+}
+
+function renderHistory(records) {
+  const body = $("history");
+  body.replaceChildren();
+  for (const r of records) {
+    const row = body.insertRow();
+    cell(row, r.Name || r.Infohash, "name");
+    cell(row, r.Category);
+    cell(row, humanBytes(r.Size));
+    cell(row, r.Ratio.toFixed(2));
+    cell(row, new Date(r.Finished).toLocaleString());
+    cell(row, r.Outcome);
+  }
+  if (records.length === 0) {
+    cell(body.insertRow(), "Nothing yet.", "dim").colSpan = 6;
+  }
+}
+
+function showError(err) {
+  if (err instanceof LoggedOut) {
+    $("dashboard").hidden = true;
+    $("logout").hidden = true;
+    $("login").hidden = false;
+    return;
+  }
+  $("error").textContent = err.message;
+  $("error").hidden = false;
+}
+
+async function refresh() {
+  try {
+    const [tasks, history] = await Promise.all([call("GET", "tasks"), call("GET", "history")]);
+    renderTasks(tasks);
+    renderHistory(history);
+    $("error").hidden = true;
+    $("dashboard").hidden = false;
+  } catch (err) {
+    showError(err);
+  }
+}
+
+async function act(action) {
+  try {
+    await action();
+  } catch (err) {
+    showError(err);
+    return;
+  }
+  await refresh();
+}
+
+$("add").onsubmit = (e) => {
+  e.preventDefault();
+  const form = e.target;
+  act(async () => {
+    await call("POST", "tasks", {magnet: form.magnet.value.trim(), category: form.category.value});
+    form.reset();
+  });
+};
+
+$("logout").onclick = async () => {
+  await fetch("logout", {method: "POST"});
+  showError(new LoggedOut());
+};
+
+refresh();
+setInterval(() => {
+  if (!$("login").hidden) {
+    return;
+  }
+  refresh();
+}, refreshInterval);
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"n2bot/ariactr"
+	"n2bot/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebAuth(t *testing.T) {
+	a, err := newWebAuth(&webConfig{BaseURL: "https://nas.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := a.newLoginToken("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := a.redeem(token); ok == false || user != "1" {
+		t.Fatalf("redeem: got %q %v", user, ok)
+	}
+	if _, ok := a.redeem(token); ok {
+		t.Error("login token is redeemed twice")
+	}
+
+	req := httptest.NewRequest("GET", "/web/api/tasks", nil)
+	req.AddCookie(a.newSession("1"))
+	if a.newSession("1").Secure == false {
+		t.Error("session cookie of HTTPS dashboard isn't secure")
+	}
+	if user, ok := a.sessionUser(req); ok == false || user != "1" {
+		t.Errorf("session: got %q %v", user, ok)
+	}
+	forged := a.newSession("1")
+	forged.Value = "2" + strings.TrimPrefix(forged.Value, "1")
+	req = httptest.NewRequest("GET", "/web/api/tasks", nil)
+	req.AddCookie(forged)
+	if _, ok := a.sessionUser(req); ok {
+		t.Error("forged session is accepted")
+	}
+}
+
+func TestWebRoutes(t *testing.T) {
+	aria := fakeAria2(t)
+	defer aria.Close()
+	ac, err := ariactr.NewClient(&ariactr.Config{Aria2RPCURL: aria.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	web, err := newWebAuth(&webConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{ariaClient: ac, tasks: &taskStore{db: storage.NewMemory()}, web: web}
+	app.current.Store(newSettings(config{Users: []string{"1"}}))
+	if err = app.tasks.save("1", "gid1", &downloadTaskInfo{TaskStage: stageBTDownload, DLType: movies, BTName: "film"}); err != nil {
+		t.Fatal(err)
+	}
+	h := httpRoutes(&apiConfig{Token: "secret"}, &healthConfig{}, app)
+	do := func(method, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/web/", nil); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "/weblogin") == false {
+		t.Errorf("index: %d", rec.Code)
+	}
+	if rec := do("GET", "/web/api/tasks", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no session: got %d", rec.Code)
+	}
+	token, _ := web.newLoginToken("1")
+	// Link previews open the link, only the form posted by the user logs in.
+	rec := do("GET", "/web/login?token="+token, nil)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), token) == false || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("login page: %d %s", rec.Code, rec.Body)
+	}
+	req := httptest.NewRequest("POST", "/web/login", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusSeeOther || len(cookies) != 1 {
+		t.Fatalf("login: %d %v", rec.Code, cookies)
+	}
+	rec = do("GET", "/web/api/tasks", cookies[0])
+	var tasks []apiTask
+	if err = json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil || len(tasks) != 1 || tasks[0].Status == nil {
+		t.Errorf("list: %d %s", rec.Code, rec.Body)
+	}
+	if rec = do("DELETE", "/web/api/tasks/nope", cookies[0]); rec.Code != http.StatusNotFound {
+		t.Errorf("kill unknown task: %d %s", rec.Code, rec.Body)
+	}
+}
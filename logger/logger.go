@@ -0,0 +1,275 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Logger writes structured leveled records without blocking the caller.
+// Records are queued and written by a single goroutine.
+// All methods are no-op on nil Logger so it is safe to leave it unset, e.g. in tests.
+type Logger struct {
+	sl   *slog.Logger
+	core *core
+}
+
+// core is shared by the root Logger and all the loggers derived from it.
+type core struct {
+	queue        chan record
+	done         chan struct{}
+	closeOnce    sync.Once
+	dropped      atomic.Int64
+	defaultLevel slog.Level
+	levels       map[string]slog.Level
+	// sinks receive records at or above their level besides the output, e.g. to notify admins.
+	sinksMu sync.RWMutex
+	sinks   []sink
+}
+
+type record struct {
+	h         slog.Handler
+	r         slog.Record
+	component string
+}
+
+type sink struct {
+	level slog.Level
+	f     func(e Entry)
+}
+
+// Entry is the record passed to sinks.
+type Entry struct {
+	Time      time.Time
+	Level     slog.Level
+	Component string
+	Message   string
+	// Fields are the record's own fields formatted as "key=value" pairs.
+	// Fields added with With aren't included.
+	Fields string
+}
+
+// Debug logs at debug level. Args are key-value pairs of context fields.
+func (l *Logger) Debug(msg string, args ...any) {
+	l.log(slog.LevelDebug, msg, args...)
+}
+
+// Info logs at info level. Args are key-value pairs of context fields.
+func (l *Logger) Info(msg string, args ...any) {
+	l.log(slog.LevelInfo, msg, args...)
+}
+
+// Warn logs at warn level. Args are key-value pairs of context fields.
+func (l *Logger) Warn(msg string, args ...any) {
+	l.log(slog.LevelWarn, msg, args...)
+}
+
+// Error logs at error level. Args are key-value pairs of context fields.
+func (l *Logger) Error(msg string, args ...any) {
+	l.log(slog.LevelError, msg, args...)
+}
+
+// Fatal logs at error level, writes all the queued records and exits with status 1.
+func (l *Logger) Fatal(msg string, args ...any) {
+	if l == nil {
+		fmt.Fprintln(os.Stderr, msg, args)
+		os.Exit(1)
+	}
+	l.log(slog.LevelError, msg, args...)
+	l.Close()
+	os.Exit(1)
+}
+
+// With returns Logger adding the fields to every record, e.g. "owner" and "gid".
+func (l *Logger) With(args ...any) *Logger {
+	if l == nil {
+		return nil
+	}
+	return &Logger{l.sl.With(args...), l.core}
+}
+
+// For returns Logger of the component with its own level if one is configured.
+func (l *Logger) For(component string) *Logger {
+	if l == nil {
+		return nil
+	}
+	lvl, ok := l.core.levels[component]
+	if ok == false {
+		lvl = l.core.defaultLevel
+	}
+	h := l.sl.Handler().(*queueHandler)
+	return &Logger{
+		slog.New(&queueHandler{h.next, lvl, l.core, component}).With("component", component),
+		l.core,
+	}
+}
+
+// AddSink sets the function called for every record at or above the level.
+// Sinks are called from the writing goroutine, so they shouldn't block.
+func (l *Logger) AddSink(level slog.Level, f func(e Entry)) {
+	if l == nil {
+		return
+	}
+	l.core.sinksMu.Lock()
+	l.core.sinks = append(l.core.sinks, sink{level, f})
+	l.core.sinksMu.Unlock()
+}
+
+// RedirectStdLog makes records written with standard log package go through the Logger at info level.
+// log.Fatal mustn't be used after redirect as it exits before queued records are written, use Fatal instead.
+func (l *Logger) RedirectStdLog() {
+	if l == nil {
+		return
+	}
+	slog.SetDefault(l.sl)
+}
+
+// Dropped returns the number of records dropped because the queue was full.
+func (l *Logger) Dropped() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.core.dropped.Load()
+}
+
+// Close writes all the queued records and stops the writing goroutine.
+// Records logged after Close are dropped.
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+	l.core.closeOnce.Do(func() {
+		close(l.core.queue)
+		<-l.core.done
+	})
+}
+
+func (l *Logger) log(level slog.Level, msg string, args ...any) {
+	if l == nil {
+		return
+	}
+	l.sl.Log(context.Background(), level, msg, args...)
+}
+
+// queueHandler filters records by level and queues them for the writing goroutine.
+type queueHandler struct {
+	next      slog.Handler
+	level     slog.Level
+	core      *core
+	component string
+}
+
+func (h *queueHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *queueHandler) Handle(_ context.Context, r slog.Record) (err error) {
+	defer func() {
+		// Queue is closed on shutdown.
+		if recover() != nil {
+			h.core.dropped.Add(1)
+		}
+	}()
+	select {
+	case h.core.queue <- record{h.next, r.Clone(), h.component}:
+	default:
+		h.core.dropped.Add(1)
+	}
+	return nil
+}
+
+func (h *queueHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &queueHandler{h.next.WithAttrs(attrs), h.level, h.core, h.component}
+}
+
+func (h *queueHandler) WithGroup(name string) slog.Handler {
+	return &queueHandler{h.next.WithGroup(name), h.level, h.core, h.component}
+}
+
+func (c *core) write() {
+	defer close(c.done)
+	for rec := range c.queue {
+		rec.h.Handle(context.Background(), rec.r)
+		c.sinksMu.RLock()
+		for _, s := range c.sinks {
+			if rec.r.Level >= s.level {
+				s.f(newEntry(rec))
+			}
+		}
+		c.sinksMu.RUnlock()
+	}
+}
+
+func newEntry(rec record) Entry {
+	fields := []string{}
+	rec.r.Attrs(func(a slog.Attr) bool {
+		fields = append(fields, a.String())
+		return true
+	})
+	return Entry{
+		rec.r.Time,
+		rec.r.Level,
+		rec.component,
+		rec.r.Message,
+		strings.Join(fields, " "),
+	}
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(strings.ToUpper(s)))
+	return lvl, err
+}
+
+// New creates new Logger from config writing to w.
+// The writing goroutine is started right away, so the Logger could be used before main loop runs.
+func New(cfg *Config, w io.Writer) (*Logger, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if cfg.Format == "" {
+		cfg.Format = "text"
+	}
+	if cfg.Level == "" {
+		cfg.Level = "info"
+	}
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = 1024
+	}
+	defaultLevel, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	levels := map[string]slog.Level{}
+	for comp, s := range cfg.Levels {
+		if levels[comp], err = ParseLevel(s); err != nil {
+			return nil, fmt.Errorf("level of %s: %w", comp, err)
+		}
+	}
+	// Levels are checked by queueHandler, so everything passed on is written.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var next slog.Handler
+	switch cfg.Format {
+	case "text":
+		next = slog.NewTextHandler(w, opts)
+	case "json":
+		next = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	c := &core{
+		queue:        make(chan record, cfg.BufferSize),
+		done:         make(chan struct{}),
+		defaultLevel: defaultLevel,
+		levels:       levels,
+	}
+	go c.write()
+	return &Logger{slog.New(&queueHandler{next, defaultLevel, c, ""}), c}, nil
+}
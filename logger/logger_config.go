@@ -0,0 +1,18 @@
+package logger
+
+// Config for leveled logger.
+type Config struct {
+	// Format is "text" or "json".
+	// Format defaults to "text" when empty.
+	Format string
+	// Level is the lowest level of records written: "debug", "info", "warn" or "error".
+	// Level defaults to "info" when empty.
+	Level string
+	// Levels overrides Level per component, e.g. { aria2 = "debug" }.
+	// Components are "main", "telegram", "aria2" and "classificator".
+	Levels map[string]string
+	// BufferSize is the number of records queued for writing.
+	// Records are dropped rather than block the caller when the queue is full.
+	// BufferSize defaults to 1024 when 0.
+	BufferSize uint
+}
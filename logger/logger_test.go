@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLevelsPerComponent(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&Config{Format: "json", Level: "warn", Levels: map[string]string{"aria2": "debug"}}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.For("telegram").Info("skipped")
+	l.For("aria2").Debug("written", "gid", "abc")
+	l.For("telegram").Error("written too", "error", errors.New("boom"))
+	l.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d: %s", len(lines), buf.String())
+	}
+	var rec map[string]interface{}
+	if err = json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["component"] != "aria2" || rec["gid"] != "abc" || rec["msg"] != "written" {
+		t.Errorf("unexpected record %v", rec)
+	}
+}
+
+func TestNonBlocking(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	l, err := New(&Config{BufferSize: 1}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The writer is stuck on the first record, the second is queued, the rest are dropped.
+	for i := 0; i < 10; i++ {
+		l.Info("record")
+	}
+	if l.Dropped() == 0 {
+		t.Error("expected dropped records")
+	}
+	close(w.release)
+	l.Close()
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	l.For("main").With("owner", "1").Error("nothing happens", "error", errors.New("boom"))
+	l.Close()
+}
+
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "n2bot"
+
+// Sources of download category.
+const (
+	CategoryFromFlag       = "flag"
+	CategoryFromClassifier = "classifier"
+	// CategoryFromUser is the category selected with buttons, it is the manual override of prediction.
+	CategoryFromUser = "user"
+)
+
+// TaskCount is the number of stored tasks at the stage in the category.
+type TaskCount struct {
+	Stage    string
+	Category string
+	Count    int
+}
+
+// Metrics holds all the metrics of the bot in its own registry.
+// All the methods are safe to call on nil *Metrics, so clients work without metrics set.
+type Metrics struct {
+	registry           *prometheus.Registry
+	messagesReceived   prometheus.Counter
+	messagesSent       prometheus.Counter
+	telegramErrors     *prometheus.CounterVec
+	rpcDuration        *prometheus.HistogramVec
+	rpcErrors          *prometheus.CounterVec
+	downloadedBytes    prometheus.Counter
+	uploadedBytes      prometheus.Counter
+	classifierCalls    *prometheus.CounterVec
+	confidence         prometheus.Histogram
+	categoryChoices    *prometheus.CounterVec
+	tasks              *prometheus.Desc
+	mu                 sync.Mutex
+	countTasks         func() ([]TaskCount, error)
+	transferredPerTask map[string]transferred
+}
+
+type transferred struct {
+	down, up int64
+}
+
+// New creates Metrics registering Go runtime and process metrics along with the bot ones.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		messagesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "telegram_messages_received_total",
+			Help:      "Messages and button presses received from Telegram.",
+		}),
+		messagesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "telegram_messages_sent_total",
+			Help:      "Messages, chat actions and query answers sent to Telegram.",
+		}),
+		telegramErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "telegram_api_errors_total",
+			Help:      "Telegram API errors by error code, \"network\" when Telegram isn't reachable.",
+		}, []string{"code"}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "aria2_rpc_duration_seconds",
+			Help:      "aria2 JSON-RPC call latency by method, \"batch\" for batched calls.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"method"}),
+		rpcErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "aria2_rpc_errors_total",
+			Help:      "aria2 JSON-RPC calls failed to reach aria2 or rejected by it, by method.",
+		}, []string{"method"}),
+		downloadedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "downloaded_bytes_total",
+			Help:      "Bytes downloaded by the polled tasks since start.",
+		}),
+		uploadedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "uploaded_bytes_total",
+			Help:      "Bytes uploaded by the polled tasks since start.",
+		}),
+		classifierCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "classifier_calls_total",
+			Help:      "Category predictions by result: \"ok\", \"error\", \"cached\" or \"skipped\" while circuit is open.",
+		}, []string{"result"}),
+		confidence: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "classifier_confidence",
+			Help:      "Confidence of the predicted category.",
+			Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+		}),
+		categoryChoices: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "category_choices_total",
+			Help:      "Download categories by source: \"flag\", \"classifier\" or \"user\" selecting it with buttons.",
+		}, []string{"source"}),
+		tasks: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "tasks"),
+			"Stored tasks by stage and category.",
+			[]string{"stage", "category"},
+			nil,
+		),
+		transferredPerTask: map[string]transferred{},
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.messagesReceived,
+		m.messagesSent,
+		m.telegramErrors,
+		m.rpcDuration,
+		m.rpcErrors,
+		m.downloadedBytes,
+		m.uploadedBytes,
+		m.classifierCalls,
+		m.confidence,
+		m.categoryChoices,
+		tasksCollector{m},
+	)
+	return m
+}
+
+// Handler serves the metrics in Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// SetTaskCounter sets the function counting stored tasks on every scrape.
+func (m *Metrics) SetTaskCounter(count func() ([]TaskCount, error)) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.countTasks = count
+	m.mu.Unlock()
+}
+
+// MessageReceived counts the message received from Telegram.
+func (m *Metrics) MessageReceived() {
+	if m == nil {
+		return
+	}
+	m.messagesReceived.Inc()
+}
+
+// MessageSent counts the message Telegram accepted.
+func (m *Metrics) MessageSent() {
+	if m == nil {
+		return
+	}
+	m.messagesSent.Inc()
+}
+
+// TelegramError counts Telegram API error by its code, 0 means Telegram isn't reachable.
+func (m *Metrics) TelegramError(code int) {
+	if m == nil {
+		return
+	}
+	label := "network"
+	if code != 0 {
+		label = strconv.Itoa(code)
+	}
+	m.telegramErrors.WithLabelValues(label).Inc()
+}
+
+// ObserveRPC records aria2 call latency and counts it failed if failed is true.
+func (m *Metrics) ObserveRPC(method string, d time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.rpcDuration.WithLabelValues(method).Observe(d.Seconds())
+	if failed {
+		m.rpcErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// ObserveTransfer adds the bytes the task transferred since the previous status of it.
+// The first status of the task is the baseline, so tasks polled again after restart aren't counted twice.
+// done forgets the task.
+func (m *Metrics) ObserveTransfer(gid string, completed, uploaded int64, done bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev, ok := m.transferredPerTask[gid]
+	if done {
+		delete(m.transferredPerTask, gid)
+	} else {
+		m.transferredPerTask[gid] = transferred{completed, uploaded}
+	}
+	if ok == false {
+		return
+	}
+	if d := completed - prev.down; d > 0 {
+		m.downloadedBytes.Add(float64(d))
+	}
+	if d := uploaded - prev.up; d > 0 {
+		m.uploadedBytes.Add(float64(d))
+	}
+}
+
+// ClassifierCall counts category prediction by its result.
+func (m *Metrics) ClassifierCall(result string) {
+	if m == nil {
+		return
+	}
+	m.classifierCalls.WithLabelValues(result).Inc()
+}
+
+// ObserveConfidence records the confidence of the predicted category.
+func (m *Metrics) ObserveConfidence(c float32) {
+	if m == nil {
+		return
+	}
+	m.confidence.Observe(float64(c))
+}
+
+// CategoryChosen counts the download category by its source.
+func (m *Metrics) CategoryChosen(source string) {
+	if m == nil {
+		return
+	}
+	m.categoryChoices.WithLabelValues(source).Inc()
+}
+
+// tasksCollector reports stored tasks counted at scrape time, so the gauge never drifts from storage.
+type tasksCollector struct {
+	m *Metrics
+}
+
+func (c tasksCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.m.tasks
+}
+
+func (c tasksCollector) Collect(ch chan<- prometheus.Metric) {
+	c.m.mu.Lock()
+	count := c.m.countTasks
+	c.m.mu.Unlock()
+	if count == nil {
+		return
+	}
+	counts, err := count()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.m.tasks, err)
+		return
+	}
+	for _, tc := range counts {
+		ch <- prometheus.MustNewConstMetric(c.m.tasks, prometheus.GaugeValue, float64(tc.Count), tc.Stage, tc.Category)
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.SetTaskCounter(func() ([]TaskCount, error) {
+		return []TaskCount{{"bt_download", "movies", 2}}, nil
+	})
+	// The first status is the baseline, only the progress after it is counted.
+	m.ObserveTransfer("gid1", 100, 10, false)
+	m.ObserveTransfer("gid1", 250, 15, false)
+	m.ObserveTransfer("gid1", 300, 15, true)
+	m.ObserveTransfer("gid1", 50, 0, false)
+	m.TelegramError(429)
+	m.TelegramError(0)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, line := range []string{
+		"n2bot_downloaded_bytes_total 200",
+		"n2bot_uploaded_bytes_total 5",
+		`n2bot_tasks{category="movies",stage="bt_download"} 2`,
+		`n2bot_telegram_api_errors_total{code="429"} 1`,
+		`n2bot_telegram_api_errors_total{code="network"} 1`,
+	} {
+		if strings.Contains(string(body), line+"\n") == false {
+			t.Errorf("%q isn't reported", line)
+		}
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.MessageSent()
+	nilMetrics.ObserveTransfer("gid", 1, 1, true)
+}
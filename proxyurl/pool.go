@@ -0,0 +1,269 @@
+package proxyurl
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSourceFailures is the number of failures after which
+// the proxy acquired from ProxiesSource is dropped from the pool.
+const maxSourceFailures = 3
+
+// Pool keeps proxies from ProxiesSource and the static list,
+// checks their health periodically and picks the fastest healthy one.
+// Pool is safe for concurrent use.
+type Pool struct {
+	mu            sync.Mutex
+	proxies       []*proxyState
+	sourceAddr    string
+	size          int
+	probeURL      string
+	checkInterval time.Duration
+	cooldown      time.Duration
+	httpClient    *http.Client
+}
+
+type proxyState struct {
+	url      *url.URL
+	static   bool
+	latency  time.Duration
+	failures int
+	// probed is false till the first successful health check, latency is unknown then.
+	probed bool
+	// coolUntil is the time till which the proxy isn't picked after the failure.
+	coolUntil time.Time
+}
+
+// Run fills the pool and starts periodic health checks which stop when ctx is done.
+func (p *Pool) Run(ctx context.Context) {
+	p.refill()
+	p.checkHealth()
+	go func() {
+		ticker := time.NewTicker(p.checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.refill()
+				p.checkHealth()
+			}
+		}
+	}()
+}
+
+// Pick returns healthy proxy with the lowest latency.
+// Returns nil if there is no healthy proxy.
+func (p *Pool) Pick() *url.URL {
+	return p.pick(func(*url.URL) bool { return true })
+}
+
+// PickHTTP returns healthy plain HTTP proxy with the lowest latency
+// for the clients not supporting other schemes, e.g. aria2.
+// Returns nil if there is no healthy HTTP proxy.
+func (p *Pool) PickHTTP() *url.URL {
+	return p.pick(func(u *url.URL) bool { return u.Scheme == "http" })
+}
+
+func (p *Pool) pick(accept func(*url.URL) bool) *url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *proxyState
+	now := time.Now()
+	for _, ps := range p.proxies {
+		if now.Before(ps.coolUntil) || accept(ps.url) == false {
+			continue
+		}
+		if best == nil || faster(ps, best) {
+			best = ps
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.url
+}
+
+// faster reports whether a is known to be faster than b.
+// Proxies which weren't probed yet are picked only when there is no probed one.
+func faster(a, b *proxyState) bool {
+	if a.probed != b.probed {
+		return a.probed
+	}
+	return a.latency < b.latency
+}
+
+// MarkFailed puts the proxy to cooldown.
+func (p *Pool) MarkFailed(u *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ps := range p.proxies {
+		if ps.url.String() == u.String() {
+			ps.failures++
+			ps.coolUntil = time.Now().Add(p.cooldown)
+			return
+		}
+	}
+}
+
+// Healthy returns the number of proxies not in cooldown.
+func (p *Pool) Healthy() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	now := time.Now()
+	for _, ps := range p.proxies {
+		if now.After(ps.coolUntil) {
+			n++
+		}
+	}
+	return n
+}
+
+// refill drops repeatedly failing proxies acquired from the source
+// and requests new ones until there are enough healthy proxies.
+func (p *Pool) refill() {
+	if p.sourceAddr == "" {
+		return
+	}
+	p.mu.Lock()
+	kept := p.proxies[:0]
+	for _, ps := range p.proxies {
+		if ps.static || ps.failures < maxSourceFailures {
+			kept = append(kept, ps)
+		}
+	}
+	p.proxies = kept
+	p.mu.Unlock()
+
+	// Source could return the same proxy again, so attempts are limited.
+	for attempt := 0; attempt < p.size && p.Healthy() < p.size; attempt++ {
+		u, err := p.fetchFromSource()
+		if err != nil {
+			return
+		}
+		p.add(u, false)
+	}
+}
+
+func (p *Pool) fetchFromSource() (*url.URL, error) {
+	res, err := p.httpClient.Get(p.sourceAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	raw := strings.TrimSpace(string(b))
+	if raw == "" {
+		return nil, errors.New("proxy source returned nothing")
+	}
+	return url.Parse(raw)
+}
+
+func (p *Pool) add(u *url.URL, static bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ps := range p.proxies {
+		if ps.url.String() == u.String() {
+			return
+		}
+	}
+	p.proxies = append(p.proxies, &proxyState{url: u, static: static})
+}
+
+// checkHealth requests ProbeURL through every proxy measuring latency.
+func (p *Pool) checkHealth() {
+	p.mu.Lock()
+	proxies := make([]*url.URL, 0, len(p.proxies))
+	for _, ps := range p.proxies {
+		proxies = append(proxies, ps.url)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, u := range proxies {
+		wg.Add(1)
+		go func(u *url.URL) {
+			defer wg.Done()
+			latency, err := p.probe(u)
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			for _, ps := range p.proxies {
+				if ps.url != u {
+					continue
+				}
+				if err != nil {
+					ps.failures++
+					ps.coolUntil = time.Now().Add(p.cooldown)
+					return
+				}
+				ps.failures = 0
+				ps.latency = latency
+				ps.probed = true
+				ps.coolUntil = time.Time{}
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) probe(u *url.URL) (time.Duration, error) {
+	t, err := transportVia(http.DefaultTransport.(*http.Transport), u)
+	if err != nil {
+		return 0, err
+	}
+	defer t.CloseIdleConnections()
+	c := &http.Client{Transport: t, Timeout: 10 * time.Second}
+	start := time.Now()
+	res, err := c.Get(p.probeURL)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusProxyAuthRequired {
+		return 0, errors.New(res.Status)
+	}
+	return time.Since(start), nil
+}
+
+// NewPool creates new Pool from config.
+// Static proxies which couldn't be parsed are skipped.
+// Supported proxy URL schemes are "http", "https", "socks5" and "socks5h".
+func NewPool(cfg *Config) *Pool {
+	if cfg.PoolSize == 0 {
+		cfg.PoolSize = 3
+	}
+	if cfg.ProbeURL == "" {
+		cfg.ProbeURL = "https://api.telegram.org"
+	}
+	if cfg.HealthCheckInterval == 0 {
+		cfg.HealthCheckInterval = 60
+	}
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = 300
+	}
+	p := &Pool{
+		sourceAddr:    cfg.ProxiesSource,
+		size:          int(cfg.PoolSize),
+		probeURL:      cfg.ProbeURL,
+		checkInterval: time.Duration(cfg.HealthCheckInterval) * time.Second,
+		cooldown:      time.Duration(cfg.Cooldown) * time.Second,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, raw := range cfg.Proxies {
+		if u, err := url.Parse(raw); err == nil {
+			p.add(u, true)
+		}
+	}
+	return p
+}
@@ -0,0 +1,26 @@
+package proxyurl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPick(t *testing.T) {
+	p := NewPool(&Config{Proxies: []string{"http://new:3128", "http://slow:3128", "socks5://fast:1080"}})
+	if u := p.Pick(); u == nil || u.Host != "new:3128" {
+		t.Errorf("got %v before probes; want any proxy", u)
+	}
+	p.proxies[1].probed, p.proxies[1].latency = true, time.Second
+	p.proxies[2].probed, p.proxies[2].latency = true, time.Millisecond
+	if u := p.Pick(); u == nil || u.Host != "fast:1080" {
+		t.Errorf("got %v; want the fastest probed proxy", u)
+	}
+	if u := p.PickHTTP(); u == nil || u.Host != "slow:3128" {
+		t.Errorf("got %v; want probed HTTP proxy rather than unprobed one", u)
+	}
+	p.MarkFailed(p.proxies[1].url)
+	p.MarkFailed(p.proxies[2].url)
+	if u := p.Pick(); u == nil || u.Host != "new:3128" {
+		t.Errorf("got %v; want unprobed proxy when probed ones are cooling down", u)
+	}
+}
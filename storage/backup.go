@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExportFormat identifies JSON Lines export files.
+const ExportFormat = "n2bot-export"
+
+// exportVersion is increased whenever the export file layout changes.
+const exportVersion = 1
+
+// exportHeader is the first line of export file.
+type exportHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Count   int       `json:"count"`
+}
+
+// exportRecord is the line of export file for every stored key.
+// Value is base64 encoded as stored values are not always valid JSON.
+type exportRecord struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// ErrNotEmpty is returned by Import when the target storage already has data
+// and overwrite wasn't requested.
+var ErrNotEmpty = errors.New("target storage is not empty")
+
+// Export writes every key of the storage to w as JSON Lines.
+// The first line is the header with the number of records.
+// Backends read all the keys from the consistent snapshot,
+// so it is safe to export while the bot is running.
+func Export(db DBInstancer, w io.Writer) (int, error) {
+	data, err := db.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(exportHeader{ExportFormat, exportVersion, time.Now().UTC(), len(keys)})
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		if err = enc.Encode(exportRecord{k, data[k]}); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// Import reads JSON Lines written by Export and writes all the records in one batch.
+// The whole file is validated before anything is written:
+// header, number of records, empty and duplicate keys.
+// validate is called for every record if not nil to check the values.
+// Unless overwrite is true Import refuses to write into non-empty storage,
+// with overwrite all existing keys are deleted first.
+func Import(db DBInstancer, r io.Reader, overwrite bool, validate func(key string, value []byte) error) (int, error) {
+	sc := bufio.NewScanner(r)
+	// Default token limit is too small for bigger values.
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	if sc.Scan() == false {
+		if sc.Err() != nil {
+			return 0, sc.Err()
+		}
+		return 0, errors.New("export file is empty")
+	}
+	var h exportHeader
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil || h.Format != ExportFormat {
+		return 0, errors.New("not an n2bot export file")
+	}
+	if h.Version > exportVersion {
+		return 0, fmt.Errorf("export file version %d is newer than supported %d", h.Version, exportVersion)
+	}
+	b := &Batch{}
+	seen := map[string]bool{}
+	line := 1
+	for sc.Scan() {
+		line++
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var rec exportRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Key == "" {
+			return 0, fmt.Errorf("line %d: empty key", line)
+		}
+		if seen[rec.Key] {
+			return 0, fmt.Errorf("line %d: duplicate key %q", line, rec.Key)
+		}
+		seen[rec.Key] = true
+		if validate != nil {
+			if err := validate(rec.Key, rec.Value); err != nil {
+				return 0, fmt.Errorf("line %d: key %q: %w", line, rec.Key, err)
+			}
+		}
+		b.Set([]byte(rec.Key), rec.Value)
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	if b.Len() != h.Count {
+		return 0, fmt.Errorf("export file has %d records, header promises %d", b.Len(), h.Count)
+	}
+	existing, err := db.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) > 0 && overwrite == false {
+		return 0, ErrNotEmpty
+	}
+	clean := &Batch{}
+	for k := range existing {
+		clean.Delete([]byte(k))
+	}
+	clean.ops = append(clean.ops, b.ops...)
+	return b.Len(), db.Write(clean)
+}
+
+// Backup exports the storage to a new timestamped file in dir
+// and deletes the oldest backups leaving keep newest ones. Zero keep leaves all.
+// Returns the path of created backup.
+func Backup(db DBInstancer, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path, err := reserveBackupPath(dir)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	if _, err = Export(db, w); err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, pruneBackups(dir, keep)
+}
+
+// reserveBackupPath creates the empty file for the backup, so backups made at the same millisecond
+// get different names instead of overwriting each other.
+func reserveBackupPath(dir string) (string, error) {
+	stamp := time.Now().UTC().Format("20060102-150405.000")
+	for n := 0; ; n++ {
+		name := "n2bot-backup-" + stamp
+		if n > 0 {
+			name += fmt.Sprintf("_%d", n)
+		}
+		path := filepath.Join(dir, name+".jsonl")
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
+
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(filepath.Join(dir, "n2bot-backup-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	// Timestamped names sort chronologically.
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err = os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	src := NewMemory()
+	src.Set([]byte("task/1/a"), []byte(`{"BTName":"a"}`))
+	src.Set([]byte("gid/a"), []byte("1"))
+	src.Set([]byte("raw"), []byte{0, 1, 2})
+	var buf bytes.Buffer
+	if n, err := Export(src, &buf); err != nil || n != 3 {
+		t.Fatalf("Export = %d, %v; want 3, nil", n, err)
+	}
+
+	dst := NewMemory()
+	if n, err := Import(dst, bytes.NewReader(buf.Bytes()), false, nil); err != nil || n != 3 {
+		t.Fatalf("Import = %d, %v; want 3, nil", n, err)
+	}
+	want, _ := src.GetAll()
+	got, _ := dst.GetAll()
+	if reflect.DeepEqual(got, want) == false {
+		t.Fatalf("imported %q; want %q", got, want)
+	}
+
+	if _, err := Import(dst, bytes.NewReader(buf.Bytes()), false, nil); err != ErrNotEmpty {
+		t.Fatalf("Import into non-empty storage = %v; want ErrNotEmpty", err)
+	}
+	dst.Set([]byte("stale"), []byte("x"))
+	if _, err := Import(dst, bytes.NewReader(buf.Bytes()), true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Get([]byte("stale")); v != nil {
+		t.Fatal("overwrite left stale key")
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	header := `{"format":"n2bot-export","version":1,"count":1}` + "\n"
+	cases := map[string]string{
+		"not export":  `{"format":"other"}` + "\n",
+		"bad count":   header,
+		"empty key":   header + `{"key":"","value":"eA=="}` + "\n",
+		"duplicate":   strings.Replace(header, `"count":1`, `"count":2`, 1) + `{"key":"k","value":"eA=="}` + "\n" + `{"key":"k","value":"eA=="}` + "\n",
+		"bad base64":  header + `{"key":"k","value":"!!"}` + "\n",
+		"bad value":   header + `{"key":"bad","value":"eA=="}` + "\n",
+		"newer":       `{"format":"n2bot-export","version":1000,"count":0}` + "\n",
+		"empty input": "",
+	}
+	validate := func(key string, value []byte) error {
+		if key == "bad" {
+			return errors.New("bad value")
+		}
+		return nil
+	}
+	for name, in := range cases {
+		db := NewMemory()
+		if _, err := Import(db, strings.NewReader(in), false, validate); err == nil {
+			t.Errorf("%s: Import succeeded", name)
+		}
+		if all, _ := db.GetAll(); len(all) != 0 {
+			t.Errorf("%s: failed Import wrote %q", name, all)
+		}
+	}
+}
+
+func TestBackupKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	db := NewMemory()
+	db.Set([]byte("k"), []byte("v"))
+	for _, name := range []string{"n2bot-backup-20000101-000000.jsonl", "n2bot-backup-20000102-000000.jsonl"} {
+		if err := writeFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path, err := Backup(db, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 || files[1] != path || filepath.Base(files[0]) != "n2bot-backup-20000102-000000.jsonl" {
+		t.Fatalf("backup dir has %v; want the newest old backup and %s", files, path)
+	}
+}
+
+func TestBackupSameMillisecond(t *testing.T) {
+	dir := t.TempDir()
+	db := NewMemory()
+	db.Set([]byte("k"), []byte("v"))
+	paths := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		path, err := Backup(db, dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths[path] = true
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "n2bot-backup-*.jsonl"))
+	if len(paths) != 5 || len(files) != 5 {
+		t.Fatalf("5 backups made %d paths and %d files", len(paths), len(files))
+	}
+}
+
+func writeFile(path string) error {
+	return ioutil.WriteFile(path, []byte("{}\n"), 0600)
+}
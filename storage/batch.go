@@ -0,0 +1,41 @@
+package storage
+
+// Batch is the set of writes to be applied atomically with DBInstancer.Write.
+// Operations are applied in the order they were added.
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// Set adds the key to be set to the value.
+func (b *Batch) Set(key []byte, value []byte) {
+	b.ops = append(b.ops, batchOp{key, value, false})
+}
+
+// Delete adds the key to be deleted.
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{key, nil, true})
+}
+
+// check returns ErrInvalidKey if any key to be set is invalid, deleted keys aren't checked as they couldn't exist.
+func (b *Batch) check() error {
+	for _, op := range b.ops {
+		if op.delete {
+			continue
+		}
+		if err := checkKey(op.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
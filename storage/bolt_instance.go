@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// defaultBucket keeps the keys having no namespace.
+// Namespaces never contain "/", so the name doesn't collide with any of them.
+const defaultBucket = "/"
+
+// boltInstance keeps every namespace in its own bucket.
+// Namespace is the part of the key before the first "/",
+// e.g. "task/123/abc" is stored as "123/abc" in the "task" bucket.
+// Keys without "/" go to the default bucket.
+type boltInstance struct {
+	db *bolt.DB
+}
+
+func splitNamespace(key []byte) (bucket []byte, inner []byte) {
+	i := bytes.IndexByte(key, '/')
+	if i < 0 {
+		return []byte(defaultBucket), key
+	}
+	return key[:i], key[i+1:]
+}
+
+func joinNamespace(bucket []byte, inner []byte) string {
+	if string(bucket) == defaultBucket {
+		return string(inner)
+	}
+	return string(bucket) + "/" + string(inner)
+}
+
+func (i *boltInstance) Get(key []byte) ([]byte, error) {
+	var v []byte
+	err := i.db.View(func(tx *bolt.Tx) error {
+		bn, k := splitNamespace(key)
+		b := tx.Bucket(bn)
+		if b == nil {
+			return nil
+		}
+		if val := b.Get(k); val != nil {
+			// Values are only valid while transaction is open.
+			v = append([]byte{}, val...)
+		}
+		return nil
+	})
+	return v, err
+}
+func (i *boltInstance) GetAll() (map[string][]byte, error) {
+	return i.GetByPrefix(nil)
+}
+func (i *boltInstance) GetByPrefix(prefix []byte) (map[string][]byte, error) {
+	result := map[string][]byte{}
+	err := i.db.View(func(tx *bolt.Tx) error {
+		if bytes.IndexByte(prefix, '/') >= 0 {
+			bn, inner := splitNamespace(prefix)
+			collectPrefixed(tx.Bucket(bn), bn, inner, result)
+			return nil
+		}
+		return tx.ForEach(func(bn []byte, b *bolt.Bucket) error {
+			if string(bn) == defaultBucket {
+				collectPrefixed(b, bn, prefix, result)
+				return nil
+			}
+			if strings.HasPrefix(string(bn), string(prefix)) {
+				collectPrefixed(b, bn, nil, result)
+			}
+			return nil
+		})
+	})
+	return result, err
+}
+
+func collectPrefixed(b *bolt.Bucket, bn []byte, prefix []byte, result map[string][]byte) {
+	if b == nil {
+		return
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		result[joinNamespace(bn, k)] = append([]byte{}, v...)
+	}
+}
+
+func (i *boltInstance) Set(key []byte, value []byte) error {
+	b := &Batch{}
+	b.Set(key, value)
+	return i.Write(b)
+}
+func (i *boltInstance) Delete(key []byte) error {
+	b := &Batch{}
+	b.Delete(key)
+	return i.Write(b)
+}
+func (i *boltInstance) Write(batch *Batch) error {
+	if err := batch.check(); err != nil {
+		return err
+	}
+	return i.db.Update(func(tx *bolt.Tx) error {
+		for _, op := range batch.ops {
+			bn, k := splitNamespace(op.key)
+			if op.delete {
+				b := tx.Bucket(bn)
+				if b == nil {
+					continue
+				}
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+				continue
+			}
+			b, err := tx.CreateBucketIfNotExists(bn)
+			if err != nil {
+				return err
+			}
+			if err = b.Put(k, op.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+func (i *boltInstance) Close() error {
+	return i.db.Close()
+}
+
+func newBoltInstance(cfg *Config) (*boltInstance, error) {
+	if cfg.Path == "" {
+		cfg.Path = "n2bot.bolt"
+	}
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	return &boltInstance{db}, err
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// runConformance checks the behaviour every DBInstancer implementation must have.
+// New backends should get their own Test function calling it.
+func runConformance(t *testing.T, open func(t *testing.T) DBInstancer) {
+	t.Run("GetMissing", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		v, err := db.Get([]byte("missing"))
+		if err != nil || v != nil {
+			t.Fatalf("Get of missing key = %q, %v; want nil, nil", v, err)
+		}
+	})
+	t.Run("SetGetDelete", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		for _, k := range []string{"plain", "task/1/abc", "gid/abc"} {
+			if err := db.Set([]byte(k), []byte("v-"+k)); err != nil {
+				t.Fatal(err)
+			}
+			v, err := db.Get([]byte(k))
+			if err != nil || string(v) != "v-"+k {
+				t.Fatalf("Get(%q) = %q, %v; want %q", k, v, err, "v-"+k)
+			}
+			if err = db.Delete([]byte(k)); err != nil {
+				t.Fatal(err)
+			}
+			v, err = db.Get([]byte(k))
+			if err != nil || v != nil {
+				t.Fatalf("Get(%q) after Delete = %q, %v; want nil, nil", k, v, err)
+			}
+		}
+	})
+	t.Run("DefaultNamespace", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		db.Set([]byte("default/x"), []byte("namespaced"))
+		db.Set([]byte("x"), []byte("plain"))
+		v, _ := db.Get([]byte("default/x"))
+		if string(v) != "namespaced" {
+			t.Fatalf("Get(%q) = %q; want %q", "default/x", v, "namespaced")
+		}
+		all, err := db.GetAll()
+		if err != nil || len(all) != 2 {
+			t.Fatalf("GetAll = %q, %v; want both keys", all, err)
+		}
+	})
+	t.Run("InvalidKeys", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		for _, k := range []string{"", "task/", "/a"} {
+			if err := db.Set([]byte(k), []byte("v")); errors.Is(err, ErrInvalidKey) == false {
+				t.Errorf("Set(%q) = %v; want ErrInvalidKey", k, err)
+			}
+		}
+		b := &Batch{}
+		b.Set([]byte("ok/1"), []byte("v"))
+		b.Set([]byte("task/"), []byte("v"))
+		if err := db.Write(b); errors.Is(err, ErrInvalidKey) == false {
+			t.Errorf("Write = %v; want ErrInvalidKey", err)
+		}
+		if v, _ := db.Get([]byte("ok/1")); v != nil {
+			t.Error("batch with invalid key is partially applied")
+		}
+	})
+	t.Run("DeleteMissing", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		if err := db.Delete([]byte("ns/missing")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Overwrite", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		db.Set([]byte("k"), []byte("one"))
+		db.Set([]byte("k"), []byte("two"))
+		v, _ := db.Get([]byte("k"))
+		if string(v) != "two" {
+			t.Fatalf("Get after overwrite = %q; want %q", v, "two")
+		}
+	})
+	t.Run("GetAllAndPrefix", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		data := map[string][]byte{
+			"123":       []byte("blob"),
+			"task/1/a":  []byte("1a"),
+			"task/1/b":  []byte("1b"),
+			"task/12/c": []byte("12c"),
+			"tasks":     []byte("not a task"),
+			"gid/a":     []byte("1"),
+			"hash/ff/a": []byte("1"),
+		}
+		for k, v := range data {
+			if err := db.Set([]byte(k), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		all, err := db.GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(all, data) == false {
+			t.Fatalf("GetAll = %q; want %q", all, data)
+		}
+		cases := map[string][]string{
+			"task/1/": {"task/1/a", "task/1/b"},
+			"task/":   {"task/1/a", "task/1/b", "task/12/c"},
+			"task":    {"task/1/a", "task/1/b", "task/12/c", "tasks"},
+			"gid/a":   {"gid/a"},
+			"nope/":   {},
+			"12":      {"123"},
+		}
+		for prefix, keys := range cases {
+			got, err := db.GetByPrefix([]byte(prefix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string][]byte{}
+			for _, k := range keys {
+				want[k] = data[k]
+			}
+			if reflect.DeepEqual(got, want) == false {
+				t.Fatalf("GetByPrefix(%q) = %q; want %q", prefix, got, want)
+			}
+		}
+	})
+	t.Run("ValuesAreCopies", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		db.Set([]byte("a/1"), []byte("first"))
+		db.Set([]byte("a/2"), []byte("second"))
+		all, _ := db.GetByPrefix([]byte("a/"))
+		if string(all["a/1"]) != "first" || string(all["a/2"]) != "second" {
+			t.Fatalf("GetByPrefix values are corrupted: %q", all)
+		}
+	})
+	t.Run("WriteBatch", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		db.Set([]byte("old/1"), []byte("x"))
+		b := &Batch{}
+		b.Set([]byte("new/1"), []byte("y"))
+		b.Delete([]byte("old/1"))
+		b.Set([]byte("new/2"), []byte("z"))
+		b.Set([]byte("new/2"), []byte("zz"))
+		if err := db.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		all, err := db.GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]byte{"new/1": []byte("y"), "new/2": []byte("zz")}
+		if reflect.DeepEqual(all, want) == false {
+			t.Fatalf("GetAll after Write = %q; want %q", all, want)
+		}
+	})
+	t.Run("HistoryZeroTimes", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		finished := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+		// Downloads removed at metadata stage have no start time, records of old versions could have no finish time.
+		records := []HistoryRecord{
+			{Owner: "1", GID: "meta", Finished: finished, Outcome: "removed"},
+			{Owner: "1", GID: "old", Outcome: "complete"},
+		}
+		for n := range records {
+			if err := AddHistory(db, &records[n]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := QueryHistory(db, &HistoryQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].GID != "meta" || got[1].GID != "old" {
+			t.Fatalf("QueryHistory = %+v; want the record without finish time last", got)
+		}
+		if got[0].Started.IsZero() == false || got[0].Finished.Equal(finished) == false || got[1].Finished.IsZero() == false {
+			t.Errorf("times aren't kept: %+v", got)
+		}
+		got, err = QueryHistory(db, &HistoryQuery{From: finished.Add(-time.Hour)})
+		if err != nil || len(got) != 1 || got[0].GID != "meta" {
+			t.Errorf("QueryHistory from = %+v, %v; want the finished record", got, err)
+		}
+	})
+	t.Run("History", func(t *testing.T) {
+		db := open(t)
+		defer db.Close()
+		base := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+		records := []HistoryRecord{
+			{Owner: "1", GID: "a", Category: "movies", Size: 10, Finished: base},
+			{Owner: "1", GID: "b", Category: "series", Size: 20, Finished: base.Add(time.Hour)},
+			{Owner: "2", GID: "c", Category: "movies", Size: 30, Finished: base.Add(2 * time.Hour), Infohash: "FF"},
+		}
+		for n := range records {
+			if err := AddHistory(db, &records[n]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cases := []struct {
+			q    HistoryQuery
+			gids []string
+		}{
+			{HistoryQuery{}, []string{"c", "b", "a"}},
+			{HistoryQuery{Owner: "1"}, []string{"b", "a"}},
+			{HistoryQuery{Category: "movies"}, []string{"c", "a"}},
+			{HistoryQuery{Infohash: "ff"}, []string{"c"}},
+			{HistoryQuery{From: base.Add(time.Hour)}, []string{"c", "b"}},
+			{HistoryQuery{To: base.Add(time.Hour)}, []string{"a"}},
+			{HistoryQuery{MinSize: 15, MaxSize: 25}, []string{"b"}},
+			{HistoryQuery{Limit: 1}, []string{"c"}},
+		}
+		for _, c := range cases {
+			got, err := QueryHistory(db, &c.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gids := []string{}
+			for _, r := range got {
+				gids = append(gids, r.GID)
+			}
+			if reflect.DeepEqual(gids, c.gids) == false {
+				t.Fatalf("QueryHistory(%+v) = %v; want %v", c.q, gids, c.gids)
+			}
+		}
+	})
+}
+
+func TestLevelConformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) DBInstancer {
+		db, err := NewInstance(&Config{"level", filepath.Join(t.TempDir(), "db")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+func TestBoltConformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) DBInstancer {
+		db, err := NewInstance(&Config{"bolt", filepath.Join(t.TempDir(), "n2bot.bolt")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+func TestSqliteConformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) DBInstancer {
+		db, err := NewInstance(&Config{"sqlite", filepath.Join(t.TempDir(), "n2bot.sqlite")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) DBInstancer {
+		return NewMemory()
+	})
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	cfg := &Config{"memory", filepath.Join(t.TempDir(), "snapshot.json")}
+	db, err := NewInstance(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set([]byte("task/1/a"), []byte("x"))
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewInstance(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := db.Get([]byte("task/1/a"))
+	if string(v) != "x" {
+		t.Fatalf("Get after snapshot reload = %q; want %q", v, "x")
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryRecord describes a finished, failed or killed download.
+type HistoryRecord struct {
+	Owner    string
+	GID      string
+	Name     string
+	Infohash string
+	Category string
+	Dir      string
+	// Size is the total size of download in bytes.
+	Size int64
+	// Uploaded is the number of bytes seeded.
+	Uploaded int64
+	Started  time.Time
+	Finished time.Time
+	// AvgSpeed is the average download speed in bytes per second.
+	AvgSpeed int64
+	// Ratio is the final share ratio.
+	Ratio float64
+	// Outcome is one of "complete", "error" or "removed".
+	Outcome string
+}
+
+// HistoryQuery filters history records. Zero values match everything.
+// Records finished at From or later and before To are matched.
+type HistoryQuery struct {
+	Owner    string
+	Category string
+	Infohash string
+	From     time.Time
+	To       time.Time
+	MinSize  int64
+	MaxSize  int64
+	// Limit is the maximum number of the most recent records to return.
+	Limit int
+}
+
+// HistoryQuerier is implemented by backends able to query history natively.
+// Other backends are scanned by key prefix.
+type HistoryQuerier interface {
+	QueryHistory(q *HistoryQuery) ([]HistoryRecord, error)
+}
+
+// HistoryKeyPrefix is the namespace of history records.
+const HistoryKeyPrefix = "history/"
+
+// HistoryKey returns the key the record is stored under:
+// history/<owner>/<finished unix nanoseconds>-<gid>.
+func HistoryKey(r *HistoryRecord) []byte {
+	return []byte(fmt.Sprintf("%s%s/%020d-%s", HistoryKeyPrefix, r.Owner, unixNano(r.Finished), r.GID))
+}
+
+// unixNano returns 0 for zero time, its UnixNano is out of int64 range.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano is the reverse of unixNano, 0 is zero time.
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// AddHistory stores history record.
+func AddHistory(db DBInstancer, r *HistoryRecord) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return db.Set(HistoryKey(r), v)
+}
+
+// QueryHistory returns history records matching the query, the most recent first.
+func QueryHistory(db DBInstancer, q *HistoryQuery) ([]HistoryRecord, error) {
+	if hq, ok := db.(HistoryQuerier); ok {
+		return hq.QueryHistory(q)
+	}
+	prefix := HistoryKeyPrefix
+	if q.Owner != "" {
+		prefix = prefix + q.Owner + "/"
+	}
+	data, err := db.GetByPrefix([]byte(prefix))
+	if err != nil {
+		return nil, err
+	}
+	records := []HistoryRecord{}
+	for _, v := range data {
+		var r HistoryRecord
+		if err = json.Unmarshal(v, &r); err != nil {
+			return nil, err
+		}
+		if q.Matches(&r) {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Finished.After(records[j].Finished)
+	})
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+	return records, nil
+}
+
+// Matches reports whether the record satisfies the query.
+func (q *HistoryQuery) Matches(r *HistoryRecord) bool {
+	if q.Owner != "" && q.Owner != r.Owner {
+		return false
+	}
+	if q.Category != "" && q.Category != r.Category {
+		return false
+	}
+	if q.Infohash != "" && strings.EqualFold(q.Infohash, r.Infohash) == false {
+		return false
+	}
+	if q.From.IsZero() == false && r.Finished.Before(q.From) {
+		return false
+	}
+	if q.To.IsZero() == false && r.Finished.Before(q.To) == false {
+		return false
+	}
+	if q.MinSize > 0 && r.Size < q.MinSize {
+		return false
+	}
+	if q.MaxSize > 0 && r.Size > q.MaxSize {
+		return false
+	}
+	return true
+}
@@ -1,6 +1,9 @@
 package storage
 
-import "github.com/syndtr/goleveldb/leveldb"
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
 
 type levelInstance struct {
 	db *leveldb.DB
@@ -14,22 +17,49 @@ func (i *levelInstance) Get(key []byte) ([]byte, error) {
 	return v, err
 }
 func (i *levelInstance) GetAll() (map[string][]byte, error) {
+	return i.GetByPrefix(nil)
+}
+func (i *levelInstance) GetByPrefix(prefix []byte) (map[string][]byte, error) {
 	result := map[string][]byte{}
 	var err error
-	iter := i.db.NewIterator(nil, nil)
+	var rng *util.Range
+	if len(prefix) > 0 {
+		rng = util.BytesPrefix(prefix)
+	}
+	iter := i.db.NewIterator(rng, nil)
 	for iter.Next() {
-		result[string(iter.Key())] = iter.Value()
+		// Iterator reuses its buffers so the value has to be copied.
+		v := make([]byte, len(iter.Value()))
+		copy(v, iter.Value())
+		result[string(iter.Key())] = v
 	}
 	iter.Release()
 	err = iter.Error()
 	return result, err
 }
 func (i *levelInstance) Set(key []byte, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
 	return i.db.Put(key, value, nil)
 }
 func (i *levelInstance) Delete(key []byte) error {
 	return i.db.Delete(key, nil)
 }
+func (i *levelInstance) Write(b *Batch) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	lb := new(leveldb.Batch)
+	for _, op := range b.ops {
+		if op.delete {
+			lb.Delete(op.key)
+			continue
+		}
+		lb.Put(op.key, op.value)
+	}
+	return i.db.Write(lb, nil)
+}
 func (i *levelInstance) Close() error {
 	return i.db.Close()
 }
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// memoryInstance keeps everything in a map guarded by RWMutex.
+// If snapshotPath is set the data is loaded from the file on start
+// and written back to it on Close.
+type memoryInstance struct {
+	mu           sync.RWMutex
+	data         map[string][]byte
+	snapshotPath string
+}
+
+func (i *memoryInstance) Get(key []byte) ([]byte, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	v, ok := i.data[string(key)]
+	if ok == false {
+		return nil, nil
+	}
+	return append([]byte{}, v...), nil
+}
+func (i *memoryInstance) GetAll() (map[string][]byte, error) {
+	return i.GetByPrefix(nil)
+}
+func (i *memoryInstance) GetByPrefix(prefix []byte) (map[string][]byte, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	result := map[string][]byte{}
+	for k, v := range i.data {
+		if strings.HasPrefix(k, string(prefix)) {
+			result[k] = append([]byte{}, v...)
+		}
+	}
+	return result, nil
+}
+func (i *memoryInstance) Set(key []byte, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+func (i *memoryInstance) Delete(key []byte) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.data, string(key))
+	return nil
+}
+func (i *memoryInstance) Write(b *Batch) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, op := range b.ops {
+		if op.delete {
+			delete(i.data, string(op.key))
+			continue
+		}
+		i.data[string(op.key)] = append([]byte{}, op.value...)
+	}
+	return nil
+}
+func (i *memoryInstance) Close() error {
+	if i.snapshotPath == "" {
+		return nil
+	}
+	i.mu.RLock()
+	b, err := json.Marshal(i.data)
+	i.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	// Write to temporary file first to never leave the truncated snapshot.
+	tmp := i.snapshotPath + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, i.snapshotPath)
+}
+
+// NewMemory returns empty in-memory DBInstancer which is never persisted.
+// It is handy for tests and dry runs.
+func NewMemory() DBInstancer {
+	return &memoryInstance{data: map[string][]byte{}}
+}
+
+func newMemoryInstance(cfg *Config) (*memoryInstance, error) {
+	i := &memoryInstance{
+		data:         map[string][]byte{},
+		snapshotPath: cfg.Path,
+	}
+	if cfg.Path == "" {
+		return i, nil
+	}
+	b, err := ioutil.ReadFile(cfg.Path)
+	if os.IsNotExist(err) {
+		return i, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, json.Unmarshal(b, &i.data)
+}
@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	// Pure Go SQLite driver keeps the static builds possible.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteInstance keeps tasks and history in their own tables
+// so the history could be queried by user, category, date and size.
+// The rest of the keys go to the generic key-value table.
+// Task keys "task/<owner>/<gid>" map to the tasks table
+// and history keys "history/<owner>/<id>" map to the history table,
+// so DBInstancer methods work the same way as for key-value backends.
+type sqliteInstance struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS kv (
+	key   TEXT PRIMARY KEY,
+	value BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS users (
+	id         TEXT PRIMARY KEY,
+	first_seen INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tasks (
+	owner TEXT NOT NULL REFERENCES users(id),
+	gid   TEXT NOT NULL,
+	value BLOB NOT NULL,
+	PRIMARY KEY (owner, gid)
+);
+CREATE TABLE IF NOT EXISTS history (
+	owner     TEXT NOT NULL REFERENCES users(id),
+	id        TEXT NOT NULL,
+	gid       TEXT NOT NULL,
+	name      TEXT NOT NULL,
+	infohash  TEXT NOT NULL,
+	category  TEXT NOT NULL,
+	dir       TEXT NOT NULL,
+	size      INTEGER NOT NULL,
+	uploaded  INTEGER NOT NULL,
+	started   INTEGER NOT NULL,
+	finished  INTEGER NOT NULL,
+	avg_speed INTEGER NOT NULL,
+	ratio     REAL NOT NULL,
+	outcome   TEXT NOT NULL,
+	PRIMARY KEY (owner, id)
+);
+CREATE INDEX IF NOT EXISTS history_finished ON history(finished);
+CREATE INDEX IF NOT EXISTS history_category ON history(category, finished);
+CREATE INDEX IF NOT EXISTS history_infohash ON history(infohash);
+`
+
+const historyColumns = `owner, id, gid, name, infohash, category, dir, size, uploaded, started, finished, avg_speed, ratio, outcome`
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlQuerier is satisfied by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// splitTableKey returns owner and id parts of the key with prefix.
+func splitTableKey(key, prefix string) (owner string, id string, ok bool) {
+	if strings.HasPrefix(key, prefix) == false {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, prefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func (i *sqliteInstance) Get(key []byte) ([]byte, error) {
+	var v []byte
+	var err error
+	k := string(key)
+	if owner, gid, ok := splitTableKey(k, "task/"); ok {
+		err = i.db.QueryRow(`SELECT value FROM tasks WHERE owner = ? AND gid = ?`, owner, gid).Scan(&v)
+	} else if owner, id, ok := splitTableKey(k, HistoryKeyPrefix); ok {
+		var records []HistoryRecord
+		records, _, err = queryHistoryRows(i.db, `WHERE owner = ? AND id = ?`, []interface{}{owner, id})
+		if err == nil && len(records) > 0 {
+			return json.Marshal(records[0])
+		}
+		return nil, err
+	} else {
+		err = i.db.QueryRow(`SELECT value FROM kv WHERE key = ?`, k).Scan(&v)
+	}
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return v, err
+}
+func (i *sqliteInstance) GetAll() (map[string][]byte, error) {
+	return i.GetByPrefix(nil)
+}
+func (i *sqliteInstance) GetByPrefix(prefix []byte) (map[string][]byte, error) {
+	// Read transaction makes the result consistent across the tables.
+	tx, err := i.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	result := map[string][]byte{}
+	p := string(prefix)
+	rows, err := tx.Query(`SELECT key, value FROM kv WHERE substr(CAST(key AS BLOB), 1, ?) = CAST(? AS BLOB)`, len(p), p)
+	if err != nil {
+		return nil, err
+	}
+	if err = collectRows(rows, result, ""); err != nil {
+		return nil, err
+	}
+	rows, err = tx.Query(
+		`SELECT owner || '/' || gid, value FROM tasks
+		WHERE substr(CAST('task/' || owner || '/' || gid AS BLOB), 1, ?) = CAST(? AS BLOB)`,
+		len(p), p,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = collectRows(rows, result, "task/"); err != nil {
+		return nil, err
+	}
+	records, keys, err := queryHistoryRows(
+		tx,
+		`WHERE substr(CAST('history/' || owner || '/' || id AS BLOB), 1, ?) = CAST(? AS BLOB)`,
+		[]interface{}{len(p), p},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for n, r := range records {
+		v, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		result[keys[n]] = v
+	}
+	return result, nil
+}
+
+func collectRows(rows *sql.Rows, result map[string][]byte, keyPrefix string) error {
+	defer rows.Close()
+	for rows.Next() {
+		var k string
+		var v []byte
+		if err := rows.Scan(&k, &v); err != nil {
+			return err
+		}
+		result[keyPrefix+k] = v
+	}
+	return rows.Err()
+}
+
+func (i *sqliteInstance) Set(key []byte, value []byte) error {
+	b := &Batch{}
+	b.Set(key, value)
+	return i.Write(b)
+}
+func (i *sqliteInstance) Delete(key []byte) error {
+	b := &Batch{}
+	b.Delete(key)
+	return i.Write(b)
+}
+func (i *sqliteInstance) Write(b *Batch) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		if op.delete {
+			err = sqliteDelete(tx, string(op.key))
+		} else {
+			err = sqliteSet(tx, string(op.key), op.value)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+func (i *sqliteInstance) Close() error {
+	return i.db.Close()
+}
+
+func sqliteSet(tx sqlExecer, key string, value []byte) error {
+	if owner, gid, ok := splitTableKey(key, "task/"); ok {
+		if err := ensureUser(tx, owner); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			`INSERT INTO tasks (owner, gid, value) VALUES (?, ?, ?)
+			ON CONFLICT(owner, gid) DO UPDATE SET value = excluded.value`,
+			owner, gid, value,
+		)
+		return err
+	}
+	if owner, id, ok := splitTableKey(key, HistoryKeyPrefix); ok {
+		var r HistoryRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return err
+		}
+		if err := ensureUser(tx, owner); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			`INSERT OR REPLACE INTO history (`+historyColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			owner, id, r.GID, r.Name, r.Infohash, r.Category, r.Dir,
+			r.Size, r.Uploaded, unixNano(r.Started), unixNano(r.Finished),
+			r.AvgSpeed, r.Ratio, r.Outcome,
+		)
+		return err
+	}
+	_, err := tx.Exec(
+		`INSERT INTO kv (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		key, value,
+	)
+	return err
+}
+
+func sqliteDelete(tx sqlExecer, key string) error {
+	var err error
+	if owner, gid, ok := splitTableKey(key, "task/"); ok {
+		_, err = tx.Exec(`DELETE FROM tasks WHERE owner = ? AND gid = ?`, owner, gid)
+	} else if owner, id, ok := splitTableKey(key, HistoryKeyPrefix); ok {
+		_, err = tx.Exec(`DELETE FROM history WHERE owner = ? AND id = ?`, owner, id)
+	} else {
+		_, err = tx.Exec(`DELETE FROM kv WHERE key = ?`, key)
+	}
+	return err
+}
+
+func ensureUser(tx sqlExecer, id string) error {
+	_, err := tx.Exec(
+		`INSERT OR IGNORE INTO users (id, first_seen) VALUES (?, ?)`,
+		id, time.Now().Unix(),
+	)
+	return err
+}
+
+// QueryHistory implements HistoryQuerier with SQL filtering and ordering.
+func (i *sqliteInstance) QueryHistory(q *HistoryQuery) ([]HistoryRecord, error) {
+	conds := []string{}
+	args := []interface{}{}
+	if q.Owner != "" {
+		conds = append(conds, "owner = ?")
+		args = append(args, q.Owner)
+	}
+	if q.Category != "" {
+		conds = append(conds, "category = ?")
+		args = append(args, q.Category)
+	}
+	if q.Infohash != "" {
+		conds = append(conds, "lower(infohash) = lower(?)")
+		args = append(args, q.Infohash)
+	}
+	if q.From.IsZero() == false {
+		conds = append(conds, "finished >= ?")
+		args = append(args, unixNano(q.From))
+	}
+	if q.To.IsZero() == false {
+		conds = append(conds, "finished < ?")
+		args = append(args, unixNano(q.To))
+	}
+	if q.MinSize > 0 {
+		conds = append(conds, "size >= ?")
+		args = append(args, q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		conds = append(conds, "size <= ?")
+		args = append(args, q.MaxSize)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	where += " ORDER BY finished DESC"
+	if q.Limit > 0 {
+		where += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	records, _, err := queryHistoryRows(i.db, where, args)
+	return records, err
+}
+
+// queryHistoryRows returns history records with the keys they are stored under.
+func queryHistoryRows(q sqlQuerier, where string, args []interface{}) ([]HistoryRecord, []string, error) {
+	rows, err := q.Query(`SELECT `+historyColumns+` FROM history `+where, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	records := []HistoryRecord{}
+	keys := []string{}
+	for rows.Next() {
+		var r HistoryRecord
+		var id string
+		var started, finished int64
+		err = rows.Scan(
+			&r.Owner, &id, &r.GID, &r.Name, &r.Infohash, &r.Category, &r.Dir,
+			&r.Size, &r.Uploaded, &started, &finished, &r.AvgSpeed, &r.Ratio, &r.Outcome,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.Started = fromUnixNano(started)
+		r.Finished = fromUnixNano(finished)
+		records = append(records, r)
+		keys = append(keys, HistoryKeyPrefix+r.Owner+"/"+id)
+	}
+	return records, keys, rows.Err()
+}
+
+func newSqliteInstance(cfg *Config) (*sqliteInstance, error) {
+	if cfg.Path == "" {
+		cfg.Path = "n2bot.sqlite"
+	}
+	db, err := sql.Open("sqlite", cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, one connection avoids "database is locked" errors.
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(`PRAGMA journal_mode = WAL; PRAGMA busy_timeout = 5000;`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err = db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteInstance{db}, nil
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 )
 
@@ -9,15 +11,44 @@ import (
 type DBInstancer interface {
 	Get(key []byte) ([]byte, error)
 	GetAll() (map[string][]byte, error)
+	// GetByPrefix returns all the keys starting with prefix with their values.
+	GetByPrefix(prefix []byte) (map[string][]byte, error)
+	// Set returns ErrInvalidKey for the key which couldn't be stored by every backend.
 	Set(key []byte, value []byte) error
 	Delete(key []byte) error
+	// Write applies all the operations of the batch atomically.
+	Write(b *Batch) error
 	Close() error
 }
 
+// ErrInvalidKey is returned on setting the key which isn't stored the same way by every backend:
+// empty key, the key with empty namespace like "/a" or the key with nothing after namespace like "task/".
+var ErrInvalidKey = errors.New("invalid key")
+
+func checkKey(key []byte) error {
+	i := bytes.IndexByte(key, '/')
+	switch {
+	case len(key) == 0:
+		return fmt.Errorf("%w: key is empty", ErrInvalidKey)
+	case i == 0:
+		return fmt.Errorf("%w %q: namespace is empty", ErrInvalidKey, key)
+	case i == len(key)-1:
+		return fmt.Errorf("%w %q: nothing after namespace", ErrInvalidKey, key)
+	}
+	return nil
+}
+
 // NewInstance returns new instance of database wrapped in common DBInstancer interface
 func NewInstance(cfg *Config) (DBInstancer, error) {
-	if "level" == cfg.BackendType {
+	switch cfg.BackendType {
+	case "level":
 		return newLevelInstance(cfg)
+	case "bolt":
+		return newBoltInstance(cfg)
+	case "sqlite":
+		return newSqliteInstance(cfg)
+	case "memory":
+		return newMemoryInstance(cfg)
 	}
 	return nil, fmt.Errorf("%s not implemented yet", cfg.BackendType)
 }
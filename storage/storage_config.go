@@ -3,9 +3,29 @@ package storage
 // Config for storage backend package.
 type Config struct {
 	// BackendType is the type of DB used to store per user per download data.
-	// Currently only "level" could be set to use goleveldb as the storage backend.
+	// "level" could be set to use goleveldb, "bolt" to use bbolt
+	// or "sqlite" to use SQLite as the storage backend.
+	// SQLite keeps tasks and download history in tables to query the history.
+	// "memory" keeps everything in memory, it is meant for tests and dry runs.
 	BackendType string
 	// Path is the relative path to database file in case of goleveldb, boltdb, badgerdb
-	// or connection url for SQLs. Note that only goleveldb is available right now.
+	// or connection url for SQLs. For SQLite it is the database file path or "file:" URI.
+	// Path defaults to "db" for goleveldb, to "n2bot.bolt" for boltdb
+	// and to "n2bot.sqlite" for SQLite.
+	// For in-memory storage Path is the optional JSON snapshot file
+	// loaded on start and written on shutdown. Nothing is persisted when empty.
 	Path string
 }
+
+// BackupConfig for scheduled online backups.
+type BackupConfig struct {
+	// Dir is the directory to write backups to.
+	// Scheduled backups are off when empty.
+	Dir string
+	// IntervalHours is the time in hours between scheduled backups.
+	// IntervalHours defaults to 24 when 0.
+	IntervalHours uint
+	// Keep is the number of the newest backups to keep in Dir, older ones are deleted.
+	// All backups are kept when 0.
+	Keep uint
+}
@@ -0,0 +1,240 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is the health state of supervised component.
+type State byte
+
+const (
+	// Starting component is started or restarted but hasn't reported it is up yet.
+	Starting State = iota
+	// Up component works fine.
+	Up
+	// Down component failed and waits for restart.
+	Down
+	// Stopped component returned without error or its context is done, it won't be restarted.
+	Stopped
+)
+
+func (s State) String() string {
+	switch s {
+	case Starting:
+		return "starting"
+	case Up:
+		return "up"
+	case Down:
+		return "down"
+	case Stopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// Supervisor runs components restarting failed ones with exponential backoff.
+// Component fails by returning an error or panicking.
+// Supervisor is safe for concurrent use, all its methods are no-op on nil Supervisor
+// so clients could call them unconditionally.
+type Supervisor struct {
+	mu             sync.Mutex
+	components     map[string]*component
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	budget         int
+	window         time.Duration
+	failures       []time.Time
+	onChange       func(e Event)
+	exhausted      chan error
+}
+
+type component struct {
+	state   State
+	backoff time.Duration
+	// wasDown is set after failure to tell recovery apart from the first start.
+	wasDown bool
+}
+
+// Go starts the component in its own goroutine.
+// Component isn't restarted once ctx is done.
+// Returned channel is closed when the component is stopped.
+func (s *Supervisor) Go(ctx context.Context, name string, run func(ctx context.Context) error) <-chan struct{} {
+	done := make(chan struct{})
+	if s == nil {
+		close(done)
+		return done
+	}
+	s.mu.Lock()
+	s.components[name] = &component{backoff: s.initialBackoff}
+	s.mu.Unlock()
+	go func() {
+		defer close(done)
+		for {
+			err := runSafe(ctx, run)
+			if err == nil || ctx.Err() != nil {
+				s.setState(name, Stopped, nil)
+				return
+			}
+			backoff := s.fail(name, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				s.setState(name, Stopped, nil)
+				return
+			}
+			s.setState(name, Starting, nil)
+		}
+	}()
+	return done
+}
+
+// MarkUp is called by the component when it works fine, e.g. after successful request.
+// The backoff of component is reset.
+func (s *Supervisor) MarkUp(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.components[name]
+	if ok == false || c.state == Up {
+		s.mu.Unlock()
+		return
+	}
+	c.backoff = s.initialBackoff
+	s.mu.Unlock()
+	s.setState(name, Up, nil)
+}
+
+// Event describes the change of component state.
+type Event struct {
+	Component string
+	State     State
+	// Err is the failure reason when State is Down.
+	Err error
+	// Recovered is set when component is up after it was down.
+	Recovered bool
+}
+
+// OnStateChange sets the function called whenever any component changes its state.
+// Function is called synchronously, so it shouldn't block.
+func (s *Supervisor) OnStateChange(f func(e Event)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.onChange = f
+	s.mu.Unlock()
+}
+
+// States returns the current states of all components.
+func (s *Supervisor) States() map[string]State {
+	states := map[string]State{}
+	if s == nil {
+		return states
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, c := range s.components {
+		states[name] = c.state
+	}
+	return states
+}
+
+// Exhausted returns the channel receiving an error once the error budget is exceeded.
+func (s *Supervisor) Exhausted() <-chan error {
+	if s == nil {
+		return nil
+	}
+	return s.exhausted
+}
+
+func (s *Supervisor) fail(name string, err error) time.Duration {
+	s.mu.Lock()
+	c := s.components[name]
+	backoff := c.backoff
+	c.backoff *= 2
+	if c.backoff > s.maxBackoff {
+		c.backoff = s.maxBackoff
+	}
+	now := time.Now()
+	kept := s.failures[:0]
+	for _, t := range s.failures {
+		if now.Sub(t) < s.window {
+			kept = append(kept, t)
+		}
+	}
+	s.failures = append(kept, now)
+	exceeded := len(s.failures) > s.budget
+	s.mu.Unlock()
+
+	s.setState(name, Down, err)
+	if exceeded {
+		select {
+		case s.exhausted <- fmt.Errorf("error budget exceeded, last failure of %s: %w", name, err):
+		default:
+		}
+	}
+	return backoff
+}
+
+func (s *Supervisor) setState(name string, state State, err error) {
+	s.mu.Lock()
+	c := s.components[name]
+	if c.state == state && err == nil {
+		s.mu.Unlock()
+		return
+	}
+	e := Event{name, state, err, state == Up && c.wasDown}
+	switch state {
+	case Down:
+		c.wasDown = true
+	case Up:
+		c.wasDown = false
+	}
+	c.state = state
+	onChange := s.onChange
+	s.mu.Unlock()
+	if onChange != nil {
+		onChange(e)
+	}
+}
+
+func runSafe(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+// New creates new Supervisor from config.
+func New(cfg *Config) *Supervisor {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if cfg.InitialBackoff == 0 {
+		cfg.InitialBackoff = 1
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 60
+	}
+	if cfg.ErrorBudget == 0 {
+		cfg.ErrorBudget = 30
+	}
+	if cfg.BudgetWindow == 0 {
+		cfg.BudgetWindow = 600
+	}
+	return &Supervisor{
+		components:     map[string]*component{},
+		initialBackoff: time.Duration(cfg.InitialBackoff) * time.Second,
+		maxBackoff:     time.Duration(cfg.MaxBackoff) * time.Second,
+		budget:         int(cfg.ErrorBudget),
+		window:         time.Duration(cfg.BudgetWindow) * time.Second,
+		exhausted:      make(chan error, 1),
+	}
+}
@@ -0,0 +1,19 @@
+package supervisor
+
+// Config for components supervisor.
+type Config struct {
+	// InitialBackoff is the time in seconds to wait before the first restart of failed component.
+	// Backoff is doubled on every failure in a row and reset once component reports it is up.
+	// InitialBackoff defaults to 1 second when 0.
+	InitialBackoff uint
+	// MaxBackoff is the longest time in seconds to wait before restart.
+	// MaxBackoff defaults to 60 seconds when 0.
+	MaxBackoff uint
+	// ErrorBudget is the number of components failures allowed within BudgetWindow.
+	// The bot exits when the budget is exceeded.
+	// ErrorBudget defaults to 30 when 0.
+	ErrorBudget uint
+	// BudgetWindow is the time in seconds failures are counted within.
+	// BudgetWindow defaults to 600 seconds when 0.
+	BudgetWindow uint
+}
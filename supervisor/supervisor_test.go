@@ -0,0 +1,170 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestSupervisor returns Supervisor with backoffs short enough for tests, config sets them in seconds.
+func newTestSupervisor(budget int) *Supervisor {
+	s := New(&Config{ErrorBudget: uint(budget)})
+	s.initialBackoff = time.Millisecond
+	s.maxBackoff = 8 * time.Millisecond
+	return s
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("component isn't stopped")
+	}
+}
+
+func TestRestartAfterError(t *testing.T) {
+	s := newTestSupervisor(100)
+	var mu sync.Mutex
+	events := []Event{}
+	s.OnStateChange(func(e Event) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	})
+	var calls atomic.Int32
+	done := s.Go(context.Background(), "poller", func(ctx context.Context) error {
+		if calls.Add(1) < 3 {
+			return errors.New("unreachable")
+		}
+		s.MarkUp("poller")
+		return nil
+	})
+	waitDone(t, done)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("run is called %d times; want 3", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	recovered := false
+	for _, e := range events {
+		recovered = recovered || (e.State == Up && e.Recovered)
+	}
+	if recovered == false {
+		t.Errorf("recovery isn't reported: %+v", events)
+	}
+	if last := events[len(events)-1]; last.State != Stopped {
+		t.Errorf("last state = %s; want stopped", last.State)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := newTestSupervisor(100)
+	s.components["c"] = &component{backoff: s.initialBackoff}
+	want := []time.Duration{1, 2, 4, 8, 8}
+	for i, w := range want {
+		if got := s.fail("c", errors.New("failed")); got != w*time.Millisecond {
+			t.Errorf("backoff %d = %s; want %s", i, got, w*time.Millisecond)
+		}
+	}
+	s.MarkUp("c")
+	if got := s.fail("c", errors.New("failed")); got != time.Millisecond {
+		t.Errorf("backoff after MarkUp = %s; want %s", got, time.Millisecond)
+	}
+	if states := s.States(); states["c"] != Down {
+		t.Errorf("state = %s; want down", states["c"])
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	s := newTestSupervisor(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := s.Go(ctx, "c", func(ctx context.Context) error {
+		return errors.New("failed")
+	})
+	select {
+	case err := <-s.Exhausted():
+		if strings.Contains(err.Error(), "failed") == false {
+			t.Errorf("exhaustion error doesn't tell the failure: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error budget isn't exhausted")
+	}
+	cancel()
+	waitDone(t, done)
+}
+
+func TestStopOnCancel(t *testing.T) {
+	s := newTestSupervisor(100)
+	s.initialBackoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	blocking := s.Go(ctx, "blocking", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	// The failed component waits for the hour long backoff.
+	backingOff := s.Go(ctx, "backing off", func(ctx context.Context) error {
+		return errors.New("failed")
+	})
+	<-started
+	cancel()
+	waitDone(t, blocking)
+	waitDone(t, backingOff)
+	for name, state := range s.States() {
+		if state != Stopped {
+			t.Errorf("%s is %s; want stopped", name, state)
+		}
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	s := newTestSupervisor(100)
+	var mu sync.Mutex
+	var failure error
+	s.OnStateChange(func(e Event) {
+		if e.State == Down {
+			mu.Lock()
+			failure = e.Err
+			mu.Unlock()
+		}
+	})
+	var calls atomic.Int32
+	done := s.Go(context.Background(), "c", func(ctx context.Context) error {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		return nil
+	})
+	waitDone(t, done)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("run is called %d times; want 2", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if failure == nil || strings.Contains(failure.Error(), "boom") == false {
+		t.Errorf("panic isn't reported as failure: %v", failure)
+	}
+}
+
+func TestNilSupervisor(t *testing.T) {
+	var s *Supervisor
+	called := false
+	waitDone(t, s.Go(context.Background(), "c", func(ctx context.Context) error {
+		called = true
+		return nil
+	}))
+	if called {
+		t.Error("nil supervisor runs components")
+	}
+	s.MarkUp("c")
+	if len(s.States()) != 0 || s.Exhausted() != nil {
+		t.Error("nil supervisor has state")
+	}
+}
@@ -2,10 +2,22 @@ package tg
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"n2bot/fatalist"
+	"n2bot/logger"
+	"n2bot/metrics"
+	"n2bot/supervisor"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the components started by Run.
+const (
+	PollerComponent = "telegram poller"
+	SenderComponent = "telegram sender"
 )
 
 // Client is a type providing the app core with the connectivity to Telegram
@@ -17,7 +29,17 @@ type Client struct {
 	inChan chan ChatMessage
 	// outChan is the channel to send messages to whenever you need to send it over Telegram
 	outChan    chan ChatMessage
-	errHandler *fatalist.Fatalist
+	logger     *logger.Logger
+	supervisor *supervisor.Supervisor
+	metrics    *metrics.Metrics
+	// wg tracks the poller and the listener.
+	wg sync.WaitGroup
+	// Sender outlives Run's ctx to deliver replies of the handlers still running, it is stopped by Shutdown.
+	stopSender context.CancelFunc
+	senderDone <-chan struct{}
+	// lastPolled is the time in Unix nanoseconds Telegram responded to getUpdates the last time.
+	// It is zero until Run is called.
+	lastPolled atomic.Int64
 }
 
 // GetInChan returns client's inChan:
@@ -34,9 +56,36 @@ func (c *Client) GetOutChan() chan<- ChatMessage {
 	return c.outChan
 }
 
-// SetErrorHandler sets a error handler function to Client.
-func (c *Client) SetErrorHandler(h *fatalist.Fatalist) {
-	c.errHandler = h
+// SetLogger sets the logger to Client.
+func (c *Client) SetLogger(l *logger.Logger) {
+	c.logger = l.For("telegram")
+}
+
+// LastPolled returns the time Telegram responded to getUpdates the last time
+// or the time Run was called if there was no response yet.
+// Long polling responds at least every ConnTimeout seconds, so older time means polling is stuck.
+func (c *Client) LastPolled() time.Time {
+	ns := c.lastPolled.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// ConnTimeout returns long polling timeout in seconds.
+func (c *Client) ConnTimeout() uint {
+	return c.connTimeout
+}
+
+// SetMetrics sets the metrics to count messages and API errors to.
+func (c *Client) SetMetrics(m *metrics.Metrics) {
+	c.metrics = m
+}
+
+// SetSupervisor sets the supervisor restarting failed poller and sender.
+// Without supervisor failed poller or sender is logged and isn't restarted.
+func (c *Client) SetSupervisor(s *supervisor.Supervisor) {
+	c.supervisor = s
 }
 
 type ChatMessage struct {
@@ -45,6 +94,8 @@ type ChatMessage struct {
 	Type          ChatMessageType             `json:"-"`
 	Keyboard      map[string][][]InlineButton `json:"reply_markup,omitempty"`
 	AnswerQueryID string                      `json:"callback_query_id,omitempty"`
+	// NoPreview keeps Telegram from fetching the links of the message to show their previews.
+	NoPreview bool `json:"disable_web_page_preview,omitempty"`
 }
 
 func (m *ChatMessage) toJSON() (msg []byte, err error) {
@@ -103,62 +154,118 @@ type user struct {
 // Run should be called on Client to start listening on inChan and outChan
 // otherwise channels would be inoperable and Telegram API never would be polled
 // if no onMessage function is passed to Run default listener would be set to keep the channel alive
-// default listener just prints out messages' sender id and text out
-func (c *Client) Run(onMessage ...func(msg ChatMessage)) {
+// default listener just prints out messages' sender id and text out.
+// Polling and listening stop when ctx is done, call Shutdown to wait for them and flush outgoing messages.
+func (c *Client) Run(ctx context.Context, onMessage ...func(msg ChatMessage)) {
+	// The first long poll may take ConnTimeout to respond, freshness is counted from start until then.
+	c.lastPolled.CompareAndSwap(0, time.Now().UnixNano())
 	if onMessage == nil {
 		c.runWithListener(
+			ctx,
 			func(msg ChatMessage) { fmt.Println(msg) },
 		)
 		return
 	}
-	c.runWithListener(onMessage[0])
+	c.runWithListener(ctx, onMessage[0])
 	return
 }
 
-func (c *Client) runWithListener(l func(msg ChatMessage)) {
+// Shutdown waits for the poller and the listener to stop after Run's ctx is done,
+// stops the sender, sends the messages left in outChan and deletes the webhook if one is set.
+// Shutdown gives up when ctx is done.
+func (c *Client) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	c.stopSender()
+	select {
+	case <-c.senderDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := c.flush(ctx); err != nil {
+		return err
+	}
+	return c.deleteWebhook(ctx)
+}
+
+func (c *Client) runWithListener(ctx context.Context, l func(msg ChatMessage)) {
+	c.wg.Add(1)
 	go func() {
+		defer c.wg.Done()
 		for {
-			msg := <-c.inChan
-			l(msg)
+			select {
+			case msg := <-c.inChan:
+				l(msg)
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
-	go c.waitForOutgoing()
-	go c.startPolling()
+	senderCtx, stopSender := context.WithCancel(context.Background())
+	c.stopSender = stopSender
+	c.senderDone = c.spawn(senderCtx, SenderComponent, c.waitForOutgoing)
+	c.wg.Add(1)
+	pollerDone := c.spawn(ctx, PollerComponent, c.startPolling)
+	go func() {
+		<-pollerDone
+		c.wg.Done()
+	}()
+}
+
+// spawn starts the loop under supervisor if one is set.
+// Returned channel is closed when the loop is stopped.
+func (c *Client) spawn(ctx context.Context, name string, loop func(ctx context.Context) error) <-chan struct{} {
+	if c.supervisor != nil {
+		return c.supervisor.Go(ctx, name, loop)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := loop(ctx); err != nil {
+			c.logger.Error("stopped", "loop", name, "error", err)
+		}
+	}()
+	return done
 }
 
-func (c *Client) startPolling() {
+func (c *Client) startPolling(ctx context.Context) error {
 	url := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates", c.token)
 	offset := 0
 	for {
 		jsonBody := []byte(
 			fmt.Sprintf(`{"timeout":%d,"offset":%d}`, c.connTimeout, offset),
 		)
-		req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonBody))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonBody))
 		if err != nil {
-			if c.errHandler != nil {
-				c.errHandler.FatalError(err)
-			}
-			return
+			return err
 		}
 		req.Header.Set("Content-Type", "application/json")
 
 		res, err := c.HttpClient.Do(req)
+		if ctx.Err() != nil {
+			return nil
+		}
 		if err != nil {
-			if c.errHandler != nil {
-				c.errHandler.LogError(err)
-			}
-			//TODO: handle connectivity errors
+			c.metrics.TelegramError(0)
+			// Connectivity errors are handled by restarting the poller with backoff.
+			return err
 		}
+		c.supervisor.MarkUp(PollerComponent)
+		c.lastPolled.Store(time.Now().UnixNano())
 
 		var updateBody apiUpdate
-		if res != nil {
-			err = json.NewDecoder(res.Body).Decode(&updateBody)
-			res.Body.Close()
-			if err != nil {
-				if c.errHandler != nil {
-					c.errHandler.LogError(err)
-				}
-			}
+		err = json.NewDecoder(res.Body).Decode(&updateBody)
+		res.Body.Close()
+		if err != nil {
+			c.logger.Error("failed to decode updates", "error", err)
 		}
 
 		updates := updateBody.Result
@@ -168,77 +275,159 @@ func (c *Client) startPolling() {
 		}
 
 		for _, m := range updates {
+			var msgs []ChatMessage
 			if m.Message.MessageID > 0 {
-				c.inChan <- ChatMessage{ChatID: fmt.Sprintf("%d", m.Message.From.ID), Text: m.Message.Text, Type: textType}
+				msgs = append(msgs, ChatMessage{ChatID: fmt.Sprintf("%d", m.Message.From.ID), Text: m.Message.Text, Type: textType})
 			}
 			if m.EditedMessage.MessageID > 0 {
-				c.inChan <- ChatMessage{ChatID: fmt.Sprintf("%d", m.EditedMessage.From.ID), Text: m.EditedMessage.Text, Type: textType}
+				msgs = append(msgs, ChatMessage{ChatID: fmt.Sprintf("%d", m.EditedMessage.From.ID), Text: m.EditedMessage.Text, Type: textType})
 			}
 			if m.CallbackQuery.From.ID > 0 {
-				c.inChan <- ChatMessage{
+				msgs = append(msgs, ChatMessage{
 					ChatID: fmt.Sprintf("%d", m.CallbackQuery.From.ID),
 					Text: fmt.Sprintf("%s -query_id=%s",
 						m.CallbackQuery.Data,
 						m.CallbackQuery.ID),
 					Type: callbackType,
+				})
+			}
+			for _, msg := range msgs {
+				c.metrics.MessageReceived()
+				select {
+				case c.inChan <- msg:
+				case <-ctx.Done():
+					// Updates aren't confirmed with offset, so Telegram returns them on the next start.
+					return nil
 				}
 			}
 		}
 	}
 }
 
-func (c *Client) waitForOutgoing() {
-	textsURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", c.token)
-	actionsURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendChatAction", c.token)
-	answerCallbackURL := fmt.Sprintf("https://api.telegram.org/bot%s/answerCallbackQuery", c.token)
+func (c *Client) waitForOutgoing(ctx context.Context) error {
 	for {
-		outMsg := <-c.outChan
-		var url string
-		msg, err := outMsg.toJSON()
-		if err != nil {
-			if c.errHandler != nil {
-				c.errHandler.LogError(err)
-			}
-		}
-		if outMsg.Type == textType {
-			url = textsURL
-		}
-		if outMsg.Type == typingType {
-			url = actionsURL
-		}
-		if outMsg.Type == callbackType {
-			url = answerCallbackURL
+		var outMsg ChatMessage
+		select {
+		case outMsg = <-c.outChan:
+		case <-ctx.Done():
+			return nil
 		}
-
-		req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(msg))
-		if err != nil {
-			if c.errHandler != nil {
-				c.errHandler.LogError(err)
-			}
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		res, err := c.HttpClient.Do(req)
+		// In-flight message isn't cancelled with ctx, it is sent before shutdown.
+		err := c.send(context.Background(), outMsg)
 		if err != nil {
-			if c.errHandler != nil {
-				c.errHandler.LogError(err)
-			}
+			// The message is sent again once the sender is restarted.
 			go func() { c.outChan <- outMsg }()
+			return err
 		}
+		c.supervisor.MarkUp(SenderComponent)
+	}
+}
 
-		var resBody map[string]interface{}
-		if res != nil {
-			err = json.NewDecoder(res.Body).Decode(&resBody)
-			if err != nil {
-				if c.errHandler != nil {
-					c.errHandler.LogError(err)
-				}
-				go func() { c.outChan <- outMsg }()
+// flush sends the messages queued to outChan until there is none for flushIdle.
+func (c *Client) flush(ctx context.Context) error {
+	const flushIdle = 200 * time.Millisecond
+	for {
+		select {
+		case outMsg := <-c.outChan:
+			if err := c.send(ctx, outMsg); err != nil {
+				return err
 			}
-			res.Body.Close()
+		case <-time.After(flushIdle):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+}
+
+// send returns error only if Telegram couldn't be reached.
+// Malformed messages are logged and dropped, the ones Telegram responded with garbage to are sent again.
+func (c *Client) send(ctx context.Context, outMsg ChatMessage) error {
+	var url string
+	msg, err := outMsg.toJSON()
+	if err != nil {
+		c.logger.Error("failed to encode message", "owner", outMsg.ChatID, "error", err)
+	}
+	if outMsg.Type == textType {
+		url = c.apiURL("sendMessage")
+	}
+	if outMsg.Type == typingType {
+		url = c.apiURL("sendChatAction")
+	}
+	if outMsg.Type == callbackType {
+		url = c.apiURL("answerCallbackQuery")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(msg))
+	if err != nil {
+		c.logger.Error("failed to create request", "owner", outMsg.ChatID, "error", err)
+		return nil
+	}
+	req.Header.Set("Content-Type", "application/json")
 
+	res, err := c.HttpClient.Do(req)
+	if err != nil {
+		c.metrics.TelegramError(0)
+		return err
+	}
+
+	var resBody struct {
+		OK        bool `json:"ok"`
+		ErrorCode int  `json:"error_code"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&resBody)
+	res.Body.Close()
+	if err != nil {
+		c.logger.Error("failed to decode send response", "owner", outMsg.ChatID, "error", err)
+		go func() { c.outChan <- outMsg }()
+		return nil
+	}
+	if resBody.OK {
+		c.metrics.MessageSent()
+	} else {
+		c.metrics.TelegramError(resBody.ErrorCode)
+	}
+	return nil
+}
+
+// deleteWebhook removes the webhook if one is set, so the bot could be polled with getUpdates again.
+func (c *Client) deleteWebhook(ctx context.Context) error {
+	var info struct {
+		Result struct {
+			URL string `json:"url"`
+		} `json:"result"`
+	}
+	if err := c.call(ctx, "getWebhookInfo", &info); err != nil {
+		return err
+	}
+	if info.Result.URL == "" {
+		return nil
+	}
+	return c.call(ctx, "deleteWebhook", nil)
+}
+
+// call makes API call without parameters decoding response to v if it isn't nil.
+func (c *Client) call(ctx context.Context, method string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL(method), nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", method, res.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (c *Client) apiURL(method string) string {
+	return fmt.Sprintf("https://api.telegram.org/bot%s/%s", c.token, method)
 }
 
 // NewClient creates an instance of tg.Client
@@ -248,7 +437,7 @@ func NewClient(cfg *Config) *Client {
 	if cfg == nil {
 		cfg = &Config{}
 	}
-	return &Client{cfg.Token, &http.Client{}, cfg.ConnTimeout, make(chan ChatMessage), make(chan ChatMessage), nil}
+	return &Client{cfg.Token, &http.Client{}, cfg.ConnTimeout, make(chan ChatMessage), make(chan ChatMessage), nil, nil, nil, sync.WaitGroup{}, nil, nil, atomic.Int64{}}
 }
 
 func NewTextMessage(chatID, text string) ChatMessage {
@@ -258,9 +447,17 @@ func NewTextMessage(chatID, text string) ChatMessage {
 		MessageTypeFromString("text"),
 		nil,
 		"",
+		false,
 	}
 }
 
+// NewTextWithoutPreview returns the text message Telegram doesn't fetch links of, so one-time links stay unused.
+func NewTextWithoutPreview(chatID, text string) ChatMessage {
+	msg := NewTextMessage(chatID, text)
+	msg.NoPreview = true
+	return msg
+}
+
 func NewTyping(chatID string) ChatMessage {
 	return ChatMessage{
 		chatID,
@@ -268,6 +465,7 @@ func NewTyping(chatID string) ChatMessage {
 		MessageTypeFromString("typing"),
 		nil,
 		"",
+		false,
 	}
 }
 
@@ -280,6 +478,7 @@ func NewTextWithKeyboard(chatID, text string, buttons []InlineButton) ChatMessag
 			"inline_keyboard": [][]InlineButton{buttons},
 		},
 		"",
+		false,
 	}
 }
 
@@ -290,6 +489,7 @@ func NewQueryAnswer(queryID string) ChatMessage {
 		MessageTypeFromString("callback"),
 		nil,
 		queryID,
+		false,
 	}
 }
 
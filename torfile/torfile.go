@@ -0,0 +1,183 @@
+package torfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strconv"
+)
+
+// Meta is the handful subset of .torrent file metadata.
+type Meta struct {
+	// Name is the suggested name of file or directory from the info dictionary.
+	Name string
+	// InfoHash is the hex encoded SHA-1 of bencoded info dictionary.
+	InfoHash string
+	// TotalLength is the sum of all files lengths in bytes.
+	TotalLength int64
+}
+
+// ErrMalformed is returned when .torrent file couldn't be decoded.
+var ErrMalformed = errors.New("malformed torrent file")
+
+// ReadFile reads and decodes .torrent file from the path provided.
+func ReadFile(path string) (*Meta, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse decodes .torrent file contents.
+func Parse(b []byte) (*Meta, error) {
+	d := &decoder{data: b}
+	root, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	rootDict, ok := root.(map[string]interface{})
+	if ok == false || d.infoEnd == 0 {
+		return nil, ErrMalformed
+	}
+	info, ok := rootDict["info"].(map[string]interface{})
+	if ok == false {
+		return nil, ErrMalformed
+	}
+	sum := sha1.Sum(b[d.infoStart:d.infoEnd])
+	meta := &Meta{
+		InfoHash: hex.EncodeToString(sum[:]),
+	}
+	if name, ok := info["name"].([]byte); ok {
+		meta.Name = string(name)
+	}
+	if l, ok := info["length"].(int64); ok {
+		meta.TotalLength = l
+	}
+	files, _ := info["files"].([]interface{})
+	for _, f := range files {
+		fd, ok := f.(map[string]interface{})
+		if ok == false {
+			continue
+		}
+		if l, ok := fd["length"].(int64); ok {
+			meta.TotalLength += l
+		}
+	}
+	return meta, nil
+}
+
+// maxDepth limits nesting of lists and dictionaries.
+// Metadata comes from peers, so deeply nested input mustn't exhaust the stack.
+// Real torrents nest a few levels at most.
+const maxDepth = 64
+
+// decoder is the minimal bencode decoder.
+// Strings are decoded as []byte, integers as int64.
+// It remembers the boundaries of top level "info" dictionary to calculate infohash.
+type decoder struct {
+	data []byte
+	pos  int
+	// depth is the number of lists and dictionaries the decoder is in.
+	depth     int
+	infoStart int
+	infoEnd   int
+}
+
+func (d *decoder) value() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, ErrMalformed
+	}
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		d.pos++
+		end := bytes.IndexByte(d.data[d.pos:], 'e')
+		if end < 0 {
+			return nil, ErrMalformed
+		}
+		n, err := strconv.ParseInt(string(d.data[d.pos:d.pos+end]), 10, 64)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		d.pos += end + 1
+		return n, nil
+	case c == 'l':
+		d.pos++
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		if d.pos >= len(d.data) {
+			return nil, ErrMalformed
+		}
+		d.depth--
+		d.pos++
+		return list, nil
+	case c == 'd':
+		d.pos++
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		dict := map[string]interface{}{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			k, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			start := d.pos
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			if d.depth == 1 && string(k) == "info" {
+				d.infoStart, d.infoEnd = start, d.pos
+			}
+			dict[string(k)] = v
+		}
+		if d.pos >= len(d.data) {
+			return nil, ErrMalformed
+		}
+		d.depth--
+		d.pos++
+		return dict, nil
+	case c >= '0' && c <= '9':
+		return d.str()
+	default:
+		return nil, ErrMalformed
+	}
+}
+
+func (d *decoder) enter() error {
+	d.depth++
+	if d.depth > maxDepth {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func (d *decoder) str() ([]byte, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return nil, ErrMalformed
+	}
+	l, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || l < 0 {
+		return nil, ErrMalformed
+	}
+	d.pos += colon + 1
+	if l > len(d.data)-d.pos {
+		return nil, ErrMalformed
+	}
+	s := d.data[d.pos : d.pos+l]
+	d.pos += l
+	return s, nil
+}
@@ -0,0 +1,69 @@
+package torfile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		info   string
+		length int64
+	}{
+		{
+			"single file",
+			"d6:lengthi1024e4:name8:film.mkv12:piece lengthi16384e6:pieces0:e",
+			1024,
+		},
+		{
+			"multiple files",
+			"d5:filesld6:lengthi100e4:pathl5:a.txteed6:lengthi250e4:pathl3:sub5:b.txteee" +
+				"4:name4:show12:piece lengthi16384e6:pieces0:e",
+			350,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := []byte("d8:announce14:http://tracker4:info" + tt.info + "e")
+			meta, err := Parse(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha1.Sum([]byte(tt.info))
+			if meta.InfoHash != hex.EncodeToString(sum[:]) {
+				t.Errorf("infohash = %s; want %x", meta.InfoHash, sum)
+			}
+			if meta.TotalLength != tt.length {
+				t.Errorf("total length = %d; want %d", meta.TotalLength, tt.length)
+			}
+			if meta.Name == "" {
+				t.Error("name is empty")
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := "d4:infod6:lengthi1e4:name1:aee"
+	inputs := map[string]string{
+		"empty":          "",
+		"no info":        "d8:announce3:urle",
+		"truncated":      valid[:len(valid)-3],
+		"truncated int":  "d4:infod6:lengthi1",
+		"long string":    "d4:info99999999999999:e",
+		"not dictionary": "l4:infoe",
+		"too deep":       "d4:info" + strings.Repeat("l", 100000) + strings.Repeat("e", 100000) + "e",
+		"deep dicts":     strings.Repeat("d1:a", maxDepth+1) + "i1e" + strings.Repeat("e", maxDepth+1),
+	}
+	for name, in := range inputs {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(in)); errors.Is(err, ErrMalformed) == false {
+				t.Errorf("got %v; want ErrMalformed", err)
+			}
+		})
+	}
+}